@@ -0,0 +1,420 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+/*Package accumulator implements an RSA accumulator over the same Content as a merkletree.MerkleTree.
+
+An RSA accumulator commits to a set with a single number A = g^(x1*x2*...*xn) mod N, where each
+xi is a prime derived from a member by HashToPrime. Unlike a Merkle path, which grows with the
+log of the size of the set, a membership or non-membership witness is a constant number of values
+modulo N. The modulus N must come from a setup that discarded its factorization, such as
+GenerateModulus or a published RSA challenge number.
+
+A Commitment binds the Merkle Root of a tree and the accumulator of its contents into one digest,
+so a client holding the digest can check either a merkletree.Proof or a witness.*/
+package accumulator
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+
+	"github.com/cbergoon/merkletree"
+)
+
+//Generator is the base g of every accumulator.
+var Generator = big.NewInt(3)
+
+//minModulusBits is the smallest modulus accepted by New.
+const minModulusBits = 1024
+
+//ErrNotMember is returned when a membership witness is requested for content not in the set.
+var ErrNotMember = errors.New("error: content is not a member of the accumulator")
+
+//ErrMember is returned when a non-membership witness is requested for content in the set.
+var ErrMember = errors.New("error: content is a member of the accumulator")
+
+//GenerateModulus returns the product of two random primes of bits/2 bits each. The primes are
+//discarded, so nobody can forge witnesses against the modulus; whoever runs it must be trusted to
+//do so.
+func GenerateModulus(bits int) (*big.Int, error) {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, err
+	}
+	return key.N, nil
+}
+
+//HashToPrime maps data to a prime of as many bits as the output of hashStrategy. It hashes data
+//followed by a big endian 64-bit counter, sets the highest and lowest bits of the result, and
+//returns the first candidate, counting up from 0, that is a probable prime.
+func HashToPrime(data []byte, hashStrategy func() hash.Hash) (*big.Int, error) {
+	if hashStrategy == nil {
+		return nil, merkletree.ErrNilHashStrategy
+	}
+	var counter [8]byte
+	for i := uint64(0); ; i++ {
+		binary.BigEndian.PutUint64(counter[:], i)
+		h := hashStrategy()
+		if _, err := h.Write(append(append([]byte{}, data...), counter[:]...)); err != nil {
+			return nil, err
+		}
+		sum := h.Sum(nil)
+		sum[0] |= 0x80
+		sum[len(sum)-1] |= 1
+		candidate := new(big.Int).SetBytes(sum)
+		if candidate.ProbablyPrime(20) {
+			return candidate, nil
+		}
+	}
+}
+
+//contentPrime returns the prime representing content, derived from its CalculateHash.
+func contentPrime(content merkletree.Content, hashStrategy func() hash.Hash) ([]byte, *big.Int, error) {
+	if content == nil {
+		return nil, nil, errors.New("error: content must not be nil")
+	}
+	element, err := content.CalculateHash()
+	if err != nil {
+		return nil, nil, err
+	}
+	prime, err := HashToPrime(element, hashStrategy)
+	if err != nil {
+		return nil, nil, err
+	}
+	return element, prime, nil
+}
+
+//expMod returns base^exp mod n, inverting base when exp is negative.
+func expMod(base, exp, n *big.Int) (*big.Int, error) {
+	if exp.Sign() < 0 {
+		inverse := new(big.Int).ModInverse(base, n)
+		if inverse == nil {
+			return nil, errors.New("error: value is not invertible modulo the modulus")
+		}
+		return new(big.Int).Exp(inverse, new(big.Int).Neg(exp), n), nil
+	}
+	return new(big.Int).Exp(base, exp, n), nil
+}
+
+//checkModulus returns an error if n cannot be the modulus of an accumulator.
+func checkModulus(n *big.Int) error {
+	if n == nil || n.Bit(0) == 0 || n.BitLen() < minModulusBits {
+		return fmt.Errorf("error: modulus must be an odd number of at least %d bits", minModulusBits)
+	}
+	if new(big.Int).GCD(nil, nil, Generator, n).Cmp(big.NewInt(1)) != 0 {
+		return errors.New("error: generator shares a factor with the modulus")
+	}
+	return nil
+}
+
+//inRange reports whether 0 < v < n.
+func inRange(v, n *big.Int) bool {
+	return v != nil && v.Sign() > 0 && v.Cmp(n) < 0
+}
+
+//Accumulator is an RSA accumulator of a set of Contents. Contents are members by the hash returned
+//by CalculateHash, so adding equal content twice adds it once.
+type Accumulator struct {
+	n            *big.Int
+	value        *big.Int
+	primes       []*big.Int
+	index        map[string]int
+	hashStrategy func() hash.Hash
+}
+
+//New returns an empty accumulator with modulus n that derives primes with hashStrategy. Returns an
+//error if the modulus is even or shorter than 1024 bits, or hashStrategy is nil.
+func New(n *big.Int, hashStrategy func() hash.Hash) (*Accumulator, error) {
+	if hashStrategy == nil {
+		return nil, merkletree.ErrNilHashStrategy
+	}
+	if err := checkModulus(n); err != nil {
+		return nil, err
+	}
+	return &Accumulator{
+		n:            new(big.Int).Set(n),
+		value:        new(big.Int).Set(Generator),
+		index:        make(map[string]int),
+		hashStrategy: hashStrategy,
+	}, nil
+}
+
+//Modulus returns the modulus N of the accumulator.
+func (a *Accumulator) Modulus() *big.Int {
+	return new(big.Int).Set(a.n)
+}
+
+//Value returns the accumulator value A.
+func (a *Accumulator) Value() *big.Int {
+	return new(big.Int).Set(a.value)
+}
+
+//Len returns the number of members of the accumulator.
+func (a *Accumulator) Len() int {
+	return len(a.primes)
+}
+
+//Add adds the contents cs to the accumulator. Contents that are already members are skipped. If a
+//content cannot be hashed, none of cs are added and the error is a *merkletree.LeafHashError with
+//the position of the content in cs.
+func (a *Accumulator) Add(cs ...merkletree.Content) error {
+	added := make(map[string]bool)
+	var elements [][]byte
+	var primes []*big.Int
+	for i, c := range cs {
+		element, prime, err := contentPrime(c, a.hashStrategy)
+		if err != nil {
+			return &merkletree.LeafHashError{Index: i, Err: err}
+		}
+		if _, ok := a.index[string(element)]; ok || added[string(element)] {
+			continue
+		}
+		added[string(element)] = true
+		elements = append(elements, element)
+		primes = append(primes, prime)
+	}
+	for i, prime := range primes {
+		a.index[string(elements[i])] = len(a.primes)
+		a.primes = append(a.primes, prime)
+		a.value.Exp(a.value, prime, a.n)
+	}
+	return nil
+}
+
+//Contains reports whether content is a member of the accumulator.
+func (a *Accumulator) Contains(content merkletree.Content) (bool, error) {
+	if content == nil {
+		return false, errors.New("error: content must not be nil")
+	}
+	element, err := content.CalculateHash()
+	if err != nil {
+		return false, err
+	}
+	_, ok := a.index[string(element)]
+	return ok, nil
+}
+
+//product returns the product of the primes of the members, skipping the member at position skip.
+func (a *Accumulator) product(skip int) *big.Int {
+	p := big.NewInt(1)
+	for i, prime := range a.primes {
+		if i != skip {
+			p.Mul(p, prime)
+		}
+	}
+	return p
+}
+
+//MembershipWitness is a witness W that the content with prime x is a member of the accumulator
+//with value A, that is W^x = A mod N.
+type MembershipWitness struct {
+	Witness *big.Int
+}
+
+//MembershipWitness returns a witness that content is a member of the accumulator. It raises g to
+//the product of the primes of every other member. Returns ErrNotMember if content is not a member.
+func (a *Accumulator) MembershipWitness(content merkletree.Content) (*MembershipWitness, error) {
+	element, prime, err := contentPrime(content, a.hashStrategy)
+	if err != nil {
+		return nil, err
+	}
+	i, ok := a.index[string(element)]
+	if !ok || a.primes[i].Cmp(prime) != 0 {
+		return nil, ErrNotMember
+	}
+	return &MembershipWitness{Witness: new(big.Int).Exp(Generator, a.product(i), a.n)}, nil
+}
+
+//Verify checks that content is a member of the accumulator with modulus n and value value whose
+//primes are derived with hashStrategy.
+func (w *MembershipWitness) Verify(n, value *big.Int, content merkletree.Content, hashStrategy func() hash.Hash) (bool, error) {
+	if err := checkModulus(n); err != nil {
+		return false, err
+	}
+	if !inRange(value, n) || !inRange(w.Witness, n) {
+		return false, errors.New("error: accumulator value and witness must be between 0 and the modulus")
+	}
+	_, prime, err := contentPrime(content, hashStrategy)
+	if err != nil {
+		return false, err
+	}
+	return new(big.Int).Exp(w.Witness, prime, n).Cmp(value) == 0, nil
+}
+
+//NonMembershipWitness is a witness (a, B) that the content with prime x is not a member of the
+//accumulator with value A, that is A^a * B^x = g mod N with 0 <= a < x.
+type NonMembershipWitness struct {
+	A *big.Int
+	B *big.Int
+}
+
+//NonMembershipWitness returns a witness that content is not a member of the accumulator. With u
+//the product of the primes of the members and x the prime of content, it solves a*u + b*x = 1 and
+//sets B = g^b. Returns ErrMember if content is a member.
+func (a *Accumulator) NonMembershipWitness(content merkletree.Content) (*NonMembershipWitness, error) {
+	element, prime, err := contentPrime(content, a.hashStrategy)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := a.index[string(element)]; ok {
+		return nil, ErrMember
+	}
+	u := a.product(-1)
+	coefficient := new(big.Int)
+	if new(big.Int).GCD(coefficient, nil, u, prime).Cmp(big.NewInt(1)) != 0 {
+		//Another member derived the same prime.
+		return nil, ErrMember
+	}
+	coefficient.Mod(coefficient, prime)
+	//b = (1 - a*u) / x is exact because a*u = 1 mod x.
+	b := new(big.Int).Mul(coefficient, u)
+	b.Sub(big.NewInt(1), b)
+	b.Quo(b, prime)
+	B, err := expMod(Generator, b, a.n)
+	if err != nil {
+		return nil, err
+	}
+	return &NonMembershipWitness{A: coefficient, B: B}, nil
+}
+
+//Verify checks that content is not a member of the accumulator with modulus n and value value
+//whose primes are derived with hashStrategy.
+func (w *NonMembershipWitness) Verify(n, value *big.Int, content merkletree.Content, hashStrategy func() hash.Hash) (bool, error) {
+	if err := checkModulus(n); err != nil {
+		return false, err
+	}
+	if !inRange(value, n) || !inRange(w.B, n) {
+		return false, errors.New("error: accumulator value and witness must be between 0 and the modulus")
+	}
+	_, prime, err := contentPrime(content, hashStrategy)
+	if err != nil {
+		return false, err
+	}
+	if w.A == nil || w.A.Sign() < 0 || w.A.Cmp(prime) >= 0 {
+		return false, nil
+	}
+	lhs := new(big.Int).Exp(value, w.A, n)
+	lhs.Mul(lhs, new(big.Int).Exp(w.B, prime, n))
+	return lhs.Mod(lhs, n).Cmp(Generator) == 0, nil
+}
+
+//Commitment binds the Merkle Root and size of a tree to the accumulator of the same contents. A
+//client that holds its Digest can check a merkletree.Proof against MerkleRoot and TreeSize or a
+//witness against Accumulator once the commitment matches the digest.
+type Commitment struct {
+	MerkleRoot  []byte
+	TreeSize    int
+	Modulus     *big.Int
+	Accumulator *big.Int
+}
+
+//NewCommitment returns the commitment to tree and acc. Returns an error unless every content of the
+//tree is a member of acc and acc has no other members. The leaves of the tree must hold their
+//content, which is not the case for trees decoded by UnmarshalBinary or kept in a node store.
+func NewCommitment(tree *merkletree.MerkleTree, acc *Accumulator) (*Commitment, error) {
+	if len(tree.Leafs) < tree.Len() {
+		return nil, errors.New("error: tree does not hold its leaves")
+	}
+	seen := make(map[string]bool)
+	for i, l := range tree.Leafs[:tree.Len()] {
+		if l.C == nil {
+			return nil, fmt.Errorf("error: leaf %d has no content", i)
+		}
+		element, err := l.C.CalculateHash()
+		if err != nil {
+			return nil, &merkletree.LeafHashError{Index: i, Err: err}
+		}
+		if _, ok := acc.index[string(element)]; !ok {
+			return nil, fmt.Errorf("%w: leaf %d", ErrNotMember, i)
+		}
+		seen[string(element)] = true
+	}
+	if len(seen) != acc.Len() {
+		return nil, fmt.Errorf("error: accumulator has %d members, tree has %d distinct contents", acc.Len(), len(seen))
+	}
+	return &Commitment{MerkleRoot: tree.MerkleRoot(), TreeSize: tree.Len(), Modulus: acc.Modulus(), Accumulator: acc.Value()}, nil
+}
+
+//Digest returns hash(len || MerkleRoot || TreeSize || len || Modulus || len || Accumulator), where
+//each len is the byte length of the field after it as a big endian uint32, the tree size is a big
+//endian uint64 and the modulus and the accumulator are big endian and padded to the byte length
+//of the modulus.
+func (c *Commitment) Digest(hashStrategy func() hash.Hash) ([]byte, error) {
+	if hashStrategy == nil {
+		return nil, merkletree.ErrNilHashStrategy
+	}
+	if err := checkModulus(c.Modulus); err != nil {
+		return nil, err
+	}
+	if !inRange(c.Accumulator, c.Modulus) {
+		return nil, errors.New("error: accumulator value must be between 0 and the modulus")
+	}
+	size := (c.Modulus.BitLen() + 7) / 8
+	if c.TreeSize <= 0 {
+		return nil, fmt.Errorf("error: invalid tree size %d", c.TreeSize)
+	}
+	accumulator := make([]byte, size)
+	c.Accumulator.FillBytes(accumulator)
+	var treeSize [8]byte
+	binary.BigEndian.PutUint64(treeSize[:], uint64(c.TreeSize))
+	data := appendField(nil, c.MerkleRoot)
+	data = append(data, treeSize[:]...)
+	data = appendField(data, c.Modulus.Bytes())
+	data = appendField(data, accumulator)
+	h := hashStrategy()
+	if _, err := h.Write(data); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+//appendField appends field to data, preceded by its byte length as a big endian uint32.
+func appendField(data, field []byte) []byte {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(field)))
+	return append(append(data, length[:]...), field...)
+}
+
+//check returns an error unless the commitment has digest digest.
+func (c *Commitment) check(digest []byte, hashStrategy func() hash.Hash) error {
+	d, err := c.Digest(hashStrategy)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(d, digest) {
+		return errors.New("error: commitment does not match digest")
+	}
+	return nil
+}
+
+//VerifyProof checks that the commitment has digest digest and that p proves content is a leaf of
+//the tree of TreeSize contents with Merkle Root MerkleRoot.
+func (c *Commitment) VerifyProof(digest []byte, content merkletree.Content, p *merkletree.Proof, hashStrategy func() hash.Hash) (bool, error) {
+	if err := c.check(digest, hashStrategy); err != nil {
+		return false, err
+	}
+	return p.VerifyContent(c.MerkleRoot, c.TreeSize, content)
+}
+
+//VerifyMembership checks that the commitment has digest digest and that w proves content is a
+//member of Accumulator.
+func (c *Commitment) VerifyMembership(digest []byte, content merkletree.Content, w *MembershipWitness, hashStrategy func() hash.Hash) (bool, error) {
+	if err := c.check(digest, hashStrategy); err != nil {
+		return false, err
+	}
+	return w.Verify(c.Modulus, c.Accumulator, content, hashStrategy)
+}
+
+//VerifyNonMembership checks that the commitment has digest digest and that w proves content is not
+//a member of Accumulator.
+func (c *Commitment) VerifyNonMembership(digest []byte, content merkletree.Content, w *NonMembershipWitness, hashStrategy func() hash.Hash) (bool, error) {
+	if err := c.check(digest, hashStrategy); err != nil {
+		return false, err
+	}
+	return w.Verify(c.Modulus, c.Accumulator, content, hashStrategy)
+}
@@ -0,0 +1,346 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package accumulator
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/cbergoon/merkletree"
+)
+
+//testContent implements the Content interface provided by merkletree.
+type testContent struct {
+	x string
+}
+
+//CalculateHash hashes the values of a testContent
+func (t testContent) CalculateHash() ([]byte, error) {
+	h := sha256.New()
+	if _, err := h.Write([]byte(t.x)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+//Equals tests for equality of two Contents
+func (t testContent) Equals(other merkletree.Content) (bool, error) {
+	o, ok := other.(testContent)
+	if !ok {
+		return false, errors.New("error: value is not of type testContent")
+	}
+	return t.x == o.x, nil
+}
+
+//failingContent is a Content whose hash cannot be calculated.
+type failingContent struct{}
+
+var errFailingContent = errors.New("error: failing content")
+
+func (failingContent) CalculateHash() ([]byte, error) {
+	return nil, errFailingContent
+}
+
+func (failingContent) Equals(other merkletree.Content) (bool, error) {
+	return false, nil
+}
+
+func contents(n int) []merkletree.Content {
+	cs := make([]merkletree.Content, n)
+	for i := range cs {
+		cs[i] = testContent{x: fmt.Sprintf("content-%d", i)}
+	}
+	return cs
+}
+
+var (
+	modulusOnce sync.Once
+	modulus     *big.Int
+	modulusErr  error
+)
+
+//testModulus returns a 1024-bit modulus shared by the tests.
+func testModulus(t *testing.T) *big.Int {
+	modulusOnce.Do(func() {
+		modulus, modulusErr = GenerateModulus(1024)
+	})
+	if modulusErr != nil {
+		t.Fatal(modulusErr)
+	}
+	return modulus
+}
+
+func TestHashToPrime(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		hs   func() hash.Hash
+		bits int
+	}{{"sha256", sha256.New, 256}, {"md5", md5.New, 128}} {
+		p, err := HashToPrime([]byte("data"), tc.hs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q, err := HashToPrime([]byte("data"), tc.hs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Cmp(q) != 0 || p.BitLen() != tc.bits || !p.ProbablyPrime(20) {
+			t.Errorf("[%s] error: expected a deterministic prime of %d bits got %v", tc.name, tc.bits, p)
+		}
+		other, err := HashToPrime([]byte("other"), tc.hs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if other.Cmp(p) == 0 {
+			t.Errorf("[%s] error: expected different data to map to different primes", tc.name)
+		}
+	}
+	if _, err := HashToPrime([]byte("data"), nil); !errors.Is(err, merkletree.ErrNilHashStrategy) {
+		t.Errorf("error: expected ErrNilHashStrategy got %v", err)
+	}
+}
+
+func TestAccumulator(t *testing.T) {
+	n := testModulus(t)
+	acc, err := New(n, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := contents(20)
+	if err := acc.Add(cs[:10]...); err != nil {
+		t.Fatal(err)
+	}
+	if err := acc.Add(append(cs[10:], cs[0], cs[19])...); err != nil {
+		t.Fatal(err)
+	}
+	if acc.Len() != 20 {
+		t.Errorf("error: expected 20 members got %d", acc.Len())
+	}
+	size := (n.BitLen() + 7) / 8
+	for i, c := range cs {
+		if ok, err := acc.Contains(c); err != nil || !ok {
+			t.Errorf("[content:%d] error: expected member: %v", i, err)
+		}
+		w, err := acc.MembershipWitness(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(w.Witness.Bytes()) > size {
+			t.Errorf("[content:%d] error: expected witness of at most %d bytes", i, size)
+		}
+		if ok, err := w.Verify(n, acc.Value(), c, sha256.New); err != nil || !ok {
+			t.Errorf("[content:%d] error: expected membership witness to verify: %v", i, err)
+		}
+		if ok, err := w.Verify(n, acc.Value(), cs[(i+1)%len(cs)], sha256.New); err != nil || ok {
+			t.Errorf("[content:%d] error: expected witness of other content to fail: %v", i, err)
+		}
+		if _, err := acc.NonMembershipWitness(c); !errors.Is(err, ErrMember) {
+			t.Errorf("[content:%d] error: expected ErrMember got %v", i, err)
+		}
+	}
+
+	for i, c := range contents(25)[20:] {
+		if _, err := acc.MembershipWitness(c); !errors.Is(err, ErrNotMember) {
+			t.Errorf("[absent:%d] error: expected ErrNotMember got %v", i, err)
+		}
+		w, err := acc.NonMembershipWitness(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := w.Verify(n, acc.Value(), c, sha256.New); err != nil || !ok {
+			t.Errorf("[absent:%d] error: expected non-membership witness to verify: %v", i, err)
+		}
+		//The witness does not prove the absence of a member.
+		if ok, err := w.Verify(n, acc.Value(), cs[i], sha256.New); err != nil || ok {
+			t.Errorf("[absent:%d] error: expected witness for a member to fail: %v", i, err)
+		}
+		//Nor does it hold once the content is added.
+		grown, err := New(n, sha256.New)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := grown.Add(append(append([]merkletree.Content{}, cs...), c)...); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := w.Verify(n, grown.Value(), c, sha256.New); err != nil || ok {
+			t.Errorf("[absent:%d] error: expected witness to fail after adding content: %v", i, err)
+		}
+	}
+
+	//The value does not depend on the order of the members.
+	reversed, err := New(n, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := len(cs) - 1; i >= 0; i-- {
+		if err := reversed.Add(cs[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reversed.Value().Cmp(acc.Value()) != 0 {
+		t.Error("error: expected the value to be independent of the order of additions")
+	}
+}
+
+func TestAccumulator_Errors(t *testing.T) {
+	n := testModulus(t)
+	if _, err := New(n, nil); !errors.Is(err, merkletree.ErrNilHashStrategy) {
+		t.Errorf("error: expected ErrNilHashStrategy got %v", err)
+	}
+	for name, bad := range map[string]*big.Int{
+		"nil":   nil,
+		"even":  new(big.Int).Lsh(n, 1),
+		"short": big.NewInt(1000003),
+		"three": new(big.Int).Mul(n, big.NewInt(3)),
+	} {
+		if _, err := New(bad, sha256.New); err == nil {
+			t.Errorf("[%s] error: expected error for modulus", name)
+		}
+	}
+	acc, err := New(n, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := acc.Add(contents(3)...); err != nil {
+		t.Fatal(err)
+	}
+	value := acc.Value()
+	var leafErr *merkletree.LeafHashError
+	if err := acc.Add(testContent{x: "new"}, failingContent{}); !errors.As(err, &leafErr) || leafErr.Index != 1 || !errors.Is(err, errFailingContent) {
+		t.Errorf("error: expected LeafHashError for content 1 got %v", err)
+	}
+	if acc.Len() != 3 || acc.Value().Cmp(value) != 0 {
+		t.Error("error: expected accumulator to be unchanged after a failed add")
+	}
+	if err := acc.Add(nil); err == nil {
+		t.Error("error: expected error adding nil content")
+	}
+
+	w, err := acc.MembershipWitness(contents(1)[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range map[string]*big.Int{"zero": big.NewInt(0), "modulus": n} {
+		if _, err := w.Verify(n, v, contents(1)[0], sha256.New); err == nil {
+			t.Errorf("[%s] error: expected error for accumulator value", name)
+		}
+	}
+	nw, err := acc.NonMembershipWitness(testContent{x: "absent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := *nw
+	large.A = new(big.Int).Add(nw.A, new(big.Int).Lsh(big.NewInt(1), 256))
+	if ok, err := large.Verify(n, acc.Value(), testContent{x: "absent"}, sha256.New); err != nil || ok {
+		t.Errorf("error: expected coefficient out of range to fail: %v", err)
+	}
+}
+
+func TestCommitment(t *testing.T) {
+	n := testModulus(t)
+	cs := contents(9)
+	tree, err := merkletree.NewTreeWithOptions(cs, merkletree.WithDomainSeparation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	acc, err := New(n, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCommitment(tree, acc); !errors.Is(err, ErrNotMember) {
+		t.Errorf("error: expected ErrNotMember got %v", err)
+	}
+	if err := acc.Add(cs...); err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewCommitment(tree, acc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := c.Digest(sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := tree.GenerateProof(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.VerifyProof(digest, cs[4], p, sha256.New); err != nil || !ok {
+		t.Errorf("error: expected Merkle proof to verify against the digest: %v", err)
+	}
+	w, err := acc.MembershipWitness(cs[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.VerifyMembership(digest, cs[4], w, sha256.New); err != nil || !ok {
+		t.Errorf("error: expected membership witness to verify against the digest: %v", err)
+	}
+	absent := testContent{x: "absent"}
+	nw, err := acc.NonMembershipWitness(absent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := c.VerifyNonMembership(digest, absent, nw, sha256.New); err != nil || !ok {
+		t.Errorf("error: expected non-membership witness to verify against the digest: %v", err)
+	}
+
+	//A commitment that was changed no longer matches the digest.
+	for name, forged := range map[string]Commitment{
+		"root":        {MerkleRoot: bytes.Repeat([]byte{1}, len(c.MerkleRoot)), TreeSize: c.TreeSize, Modulus: c.Modulus, Accumulator: c.Accumulator},
+		"size":        {MerkleRoot: c.MerkleRoot, TreeSize: c.TreeSize + 1, Modulus: c.Modulus, Accumulator: c.Accumulator},
+		"accumulator": {MerkleRoot: c.MerkleRoot, TreeSize: c.TreeSize, Modulus: c.Modulus, Accumulator: new(big.Int).Exp(c.Accumulator, big.NewInt(2), n)},
+	} {
+		if _, err := forged.VerifyMembership(digest, cs[4], w, sha256.New); err == nil {
+			t.Errorf("[%s] error: expected forged commitment to be rejected", name)
+		}
+		if _, err := forged.VerifyProof(digest, cs[4], p, sha256.New); err == nil {
+			t.Errorf("[%s] error: expected forged commitment to be rejected", name)
+		}
+	}
+
+	//Every field is preceded by its length, so bytes cannot move from one field to the next.
+	field := func(b []byte) []byte {
+		return append([]byte{byte(len(b) >> 24), byte(len(b) >> 16), byte(len(b) >> 8), byte(len(b))}, b...)
+	}
+	data := append(field(c.MerkleRoot), 0, 0, 0, 0, 0, 0, 0, byte(len(cs)))
+	data = append(data, field(c.Modulus.Bytes())...)
+	data = append(data, field(c.Accumulator.FillBytes(make([]byte, len(c.Modulus.Bytes()))))...)
+	if expected := sha256.Sum256(data); !bytes.Equal(digest, expected[:]) {
+		t.Errorf("error: expected digest %x got %x", expected, digest)
+	}
+
+	//Trees whose leaves have no content cannot be committed to.
+	encoded, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded merkletree.MerkleTree
+	if err := decoded.UnmarshalBinary(encoded); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := merkletree.NewTreeWithOptions(cs, merkletree.WithNodeStore(merkletree.NewMemoryStore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, other := range map[string]*merkletree.MerkleTree{"decoded": &decoded, "stored": stored} {
+		if _, err := NewCommitment(other, acc); err == nil {
+			t.Errorf("[%s] error: expected error for a tree without content", name)
+		}
+	}
+
+	if err := acc.Add(absent); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCommitment(tree, acc); err == nil {
+		t.Error("error: expected error for an accumulator with extra members")
+	}
+}
@@ -0,0 +1,207 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+//bloomEncodingMagic starts every binary encoded BloomFilter.
+var bloomEncodingMagic = [4]byte{'M', 'R', 'K', 'B'}
+
+//bloomEncodingVersion is the version of the binary encoding written by BloomFilter.MarshalBinary.
+const bloomEncodingVersion = 1
+
+//minBloomCapacity is the smallest number of leaves a tree sizes its filter for.
+const minBloomCapacity = 64
+
+//maxBloomHashes is the largest number of bits set per item, enough for any false positive rate
+//above the smallest float64.
+const maxBloomHashes = 1 << 10
+
+//BloomFilter is a Bloom filter over byte strings. It never reports a false negative: MayContain
+//is true for every item that was added, and for others with a probability that depends on how the
+//filter was sized.
+//
+//An item is mapped to the k bits (h1 + i*h2) mod m for i in [0, k), where h1 is the 64-bit FNV-1a
+//hash of the item, h2 is its 64-bit FNV-1 hash with the lowest bit set, and m is the number of bits.
+//Bit b is bit b mod 64 of the 64-bit word b / 64. The zero value is a filter without bits, which
+//cannot rule out any item.
+type BloomFilter struct {
+	bits  []uint64
+	m     uint64
+	k     uint32
+	count uint64
+}
+
+//NewBloomFilter returns an empty Bloom filter sized to hold n items with a false positive rate of
+//fpRate. Returns an error if fpRate is not between 0 and 1.
+func NewBloomFilter(n int, fpRate float64) (*BloomFilter, error) {
+	if !(fpRate > 0 && fpRate < 1) {
+		return nil, fmt.Errorf("error: invalid false positive rate %v", fpRate)
+	}
+	if n < 1 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	if m < 64 {
+		m = 64
+	}
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	if k > maxBloomHashes {
+		k = maxBloomHashes
+	}
+	return &BloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: k}, nil
+}
+
+//bloomHashes returns the two hashes that select the bits of item.
+func bloomHashes(item []byte) (uint64, uint64) {
+	h1 := fnv.New64a()
+	h1.Write(item)
+	h2 := fnv.New64()
+	h2.Write(item)
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+//Add adds item to the filter.
+func (b *BloomFilter) Add(item []byte) {
+	b.count++
+	if b.m == 0 {
+		return
+	}
+	h1, h2 := bloomHashes(item)
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+//MayContain reports whether item may have been added to the filter. A false result means item was
+//definitely not added.
+func (b *BloomFilter) MayContain(item []byte) bool {
+	if b.m == 0 {
+		return true
+	}
+	h1, h2 := bloomHashes(item)
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+//Len returns the number of items added to the filter.
+func (b *BloomFilter) Len() int {
+	return int(b.count)
+}
+
+//MarshalBinary implements encoding.BinaryMarshaler. All integers are big endian and the layout of
+//version 1 is:
+//
+//	magic      4 bytes "MRKB"
+//	version    uint8
+//	hashes     uint32, the number of bits k set per item
+//	bits       uint64, the number of bits m
+//	count      uint64, the number of items added
+//	words      (m + 63) / 64 uint64 words holding the bits
+func (b *BloomFilter) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(bloomEncodingMagic[:])
+	buf.WriteByte(bloomEncodingVersion)
+	for _, v := range []interface{}{b.k, b.m, b.count, b.bits} {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+//UnmarshalBinary implements encoding.BinaryUnmarshaler for the encoding described in MarshalBinary.
+func (b *BloomFilter) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var header struct {
+		Magic   [4]byte
+		Version uint8
+		K       uint32
+		M       uint64
+		Count   uint64
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil || header.Magic != bloomEncodingMagic {
+		return errors.New("error: data is not an encoded bloom filter")
+	}
+	if header.Version != bloomEncodingVersion {
+		return fmt.Errorf("error: unsupported bloom filter encoding version %d", header.Version)
+	}
+	//The number of words is taken from the data so that a large M cannot overflow it.
+	words := uint64(r.Len() / 8)
+	if header.K == 0 || header.K > maxBloomHashes || header.M == 0 || r.Len()%8 != 0 ||
+		header.M > words*64 || header.M <= (words-1)*64 {
+		return fmt.Errorf("error: invalid bloom filter of %d bits and %d hashes", header.M, header.K)
+	}
+	bits := make([]uint64, words)
+	if err := binary.Read(r, binary.BigEndian, bits); err != nil {
+		return err
+	}
+	*b = BloomFilter{bits: bits, m: header.M, k: header.K, count: header.Count}
+	return nil
+}
+
+//WithBloomFilter maintains a Bloom filter over the leaf hashes of the tree with a false positive
+//rate of about fpRate, so that lookups of absent content return without consulting the leaves.
+//The filter is extended as content is appended or updated and rebuilt when content is removed,
+//the tree is rebuilt, or it outgrows its size. See MerkleTree.BloomFilter.
+func WithBloomFilter(fpRate float64) Option {
+	return func(c *config) {
+		c.bloomFPRate = fpRate
+	}
+}
+
+//BloomFilter returns the Bloom filter over the leaf hashes of the tree, or nil if the tree was not
+//created with WithBloomFilter. The leaf hashes are the hashes taken by LeafIndex: the result of
+//CalculateHash, or hash(0x00 || CalculateHash()) for trees created with WithDomainSeparation. A
+//client holding the filter can check the leaf hash of a query before asking for its proof. The
+//filter is owned by the tree; use its MarshalBinary to hand it out.
+func (m *Tree[T]) BloomFilter() *BloomFilter {
+	return m.bloom
+}
+
+//rebuildBloom replaces the Bloom filter with one holding the current leaf hashes, sized for twice
+//as many leaves.
+func (m *Tree[T]) rebuildBloom() {
+	if m.bloomFPRate == 0 {
+		m.bloom = nil
+		return
+	}
+	m.bloomCapacity = 2 * m.leafCount()
+	if m.bloomCapacity < minBloomCapacity {
+		m.bloomCapacity = minBloomCapacity
+	}
+	//The rate was checked by validate.
+	m.bloom, _ = NewBloomFilter(m.bloomCapacity, m.bloomFPRate)
+	for _, l := range m.Leafs[:m.leafCount()] {
+		m.bloom.Add(l.Hash)
+	}
+}
+
+//addToBloom adds hash to the Bloom filter, rebuilding it from the leaves once it is full.
+func (m *Tree[T]) addToBloom(hash []byte) {
+	if m.bloom == nil {
+		return
+	}
+	if m.bloom.Len() >= m.bloomCapacity {
+		m.rebuildBloom()
+		return
+	}
+	m.bloom.Add(hash)
+}
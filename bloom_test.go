@@ -0,0 +1,215 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*BloomFilter)(nil)
+	_ encoding.BinaryUnmarshaler = (*BloomFilter)(nil)
+)
+
+func TestBloomFilter(t *testing.T) {
+	for _, fpRate := range []float64{0.1, 0.01, 0.001} {
+		n := 2000
+		b, err := NewBloomFilter(n, fpRate)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			b.Add([]byte(fmt.Sprintf("item-%d", i)))
+		}
+		for i := 0; i < n; i++ {
+			if !b.MayContain([]byte(fmt.Sprintf("item-%d", i))) {
+				t.Fatalf("[%v] error: false negative for item %d", fpRate, i)
+			}
+		}
+		falsePositives := 0
+		for i := 0; i < 20000; i++ {
+			if b.MayContain([]byte(fmt.Sprintf("absent-%d", i))) {
+				falsePositives++
+			}
+		}
+		if rate := float64(falsePositives) / 20000; rate > 2*fpRate {
+			t.Errorf("[%v] error: false positive rate %v", fpRate, rate)
+		}
+
+		data, err := b.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded BloomFilter
+		if err := decoded.UnmarshalBinary(data); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.Len() != n || decoded.k != b.k || decoded.m != b.m {
+			t.Errorf("[%v] error: expected decoded filter to match", fpRate)
+		}
+		for i := 0; i < 100; i++ {
+			item := []byte(fmt.Sprintf("absent-%d", i))
+			if decoded.MayContain(item) != b.MayContain(item) {
+				t.Errorf("[%v] error: expected decoded filter to give the same answers", fpRate)
+			}
+		}
+	}
+}
+
+func TestBloomFilter_Errors(t *testing.T) {
+	for _, fpRate := range []float64{0, 1, -0.5, 2} {
+		if _, err := NewBloomFilter(10, fpRate); err == nil {
+			t.Errorf("[%v] error: expected error for false positive rate", fpRate)
+		}
+	}
+	if _, err := NewTreeWithOptions(table[0].contents, WithBloomFilter(1.5)); err == nil {
+		t.Error("error: expected error for false positive rate")
+	}
+	b, err := NewBloomFilter(10, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := b.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//withHeader returns data with the hashes and bits fields replaced.
+	withHeader := func(k uint32, m uint64, body []byte) []byte {
+		bad := append([]byte{}, data[:25]...)
+		binary.BigEndian.PutUint32(bad[5:], k)
+		binary.BigEndian.PutUint64(bad[9:], m)
+		return append(bad, body...)
+	}
+	for name, bad := range map[string][]byte{
+		"empty":     nil,
+		"magic":     append([]byte("XXXX"), data[4:]...),
+		"version":   append(append(append([]byte{}, data[:4]...), 99), data[5:]...),
+		"truncated": data[:len(data)-1],
+		"trailing":  append(append([]byte{}, data...), 0),
+		"overflow":  withHeader(b.k, math.MaxUint64, nil),
+		"bits":      withHeader(b.k, b.m+64, data[25:]),
+		"hashes":    withHeader(maxBloomHashes+1, b.m, data[25:]),
+	} {
+		var decoded BloomFilter
+		if err := decoded.UnmarshalBinary(bad); err == nil {
+			t.Errorf("[%s] error: expected error", name)
+		}
+	}
+
+	//The zero value has no bits to rule an item out.
+	var zero BloomFilter
+	zero.Add([]byte("a"))
+	if !zero.MayContain([]byte("a")) || !zero.MayContain([]byte("b")) || zero.Len() != 1 {
+		t.Error("error: expected the zero filter to hold every item")
+	}
+}
+
+func TestMerkleTree_BloomFilter(t *testing.T) {
+	cs := numberedContents(10)
+	tree, err := NewTreeWithOptions(cs, WithBloomFilter(0.01), WithDomainSeparation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, err := NewTree(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain.BloomFilter() != nil {
+		t.Error("error: expected no filter without WithBloomFilter")
+	}
+	check := func(name string, present []Content) {
+		b := tree.BloomFilter()
+		if b == nil {
+			t.Fatalf("[%s] error: expected a filter", name)
+		}
+		for _, c := range present {
+			hash, err := tree.hashContent(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !b.MayContain(hash) {
+				t.Errorf("[%s] error: expected filter to contain %v", name, c)
+			}
+			if ok, err := tree.VerifyContent(c); err != nil || !ok {
+				t.Errorf("[%s] error: expected content to verify: %v", name, err)
+			}
+		}
+	}
+	check("build", cs)
+
+	//Absent content is rejected by the filter without calling Equals.
+	calls := 0
+	absent := countingContent{TestSHA256Content{x: "absent"}, &calls}
+	if indexes, err := tree.IndexesOf(absent); err != nil || len(indexes) != 0 {
+		t.Errorf("error: expected absent content not to be found: %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("error: expected no calls to Equals got %d", calls)
+	}
+
+	//Appending past the capacity of the filter rebuilds it without losing leaves.
+	more := numberedContents(200)
+	for _, c := range more[10:] {
+		if err := tree.Append(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check("append", more)
+	if tree.bloomCapacity < tree.leafCount() {
+		t.Errorf("error: expected capacity of at least %d got %d", tree.leafCount(), tree.bloomCapacity)
+	}
+
+	updated := TestSHA256Content{x: "updated"}
+	if _, _, err := tree.UpdateLeaf(3, updated); err != nil {
+		t.Fatal(err)
+	}
+	check("update", []Content{updated})
+
+	if err := tree.RemoveLeaf(3); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := tree.hashContent(updated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.BloomFilter().Len() != tree.leafCount() {
+		t.Errorf("error: expected rebuilt filter of %d leaves got %d", tree.leafCount(), tree.BloomFilter().Len())
+	}
+	if ok, err := tree.VerifyContent(updated); err != nil || ok {
+		t.Errorf("error: expected removed content not to verify: %v", err)
+	}
+
+	//A client holding the root and the filter pre-screens queries.
+	data, err := tree.BloomFilter().MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var client BloomFilter
+	if err := client.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if client.MayContain(hash) != tree.BloomFilter().MayContain(hash) {
+		t.Error("error: expected client filter to match")
+	}
+	if !client.MayContain(tree.Leafs[7].Hash) {
+		t.Error("error: expected client filter to contain leaf")
+	}
+
+	treeData, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded MerkleTree
+	decoded.config = tree.config
+	if err := decoded.UnmarshalBinary(treeData); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.BloomFilter() == nil || !decoded.BloomFilter().MayContain(tree.Leafs[7].Hash) {
+		t.Error("error: expected decoded tree to rebuild its filter")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+)
+
+//Chunk is a fixed-size piece of a blob, stored as a leaf of a tree built by NewTreeFromReader.
+//Index is the position of the chunk in the blob; every chunk but the last holds exactly the chunk
+//size of bytes. A Chunk created by the caller is hashed with sha256; for trees with another hash
+//strategy use the chunks returned by ChunkProof, or Proof.VerifyChunk to check received data.
+type Chunk struct {
+	Index        int
+	Data         []byte
+	hashStrategy func() hash.Hash
+}
+
+//CalculateHash hashes the data of the chunk with the hash strategy of its tree.
+func (c Chunk) CalculateHash() ([]byte, error) {
+	hashStrategy := c.hashStrategy
+	if hashStrategy == nil {
+		hashStrategy = sha256.New
+	}
+	h := hashStrategy()
+	if _, err := h.Write(c.Data); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+//Equals tests for equality of two Chunks, which must have the same index and data.
+func (c Chunk) Equals(other Content) (bool, error) {
+	o, ok := other.(Chunk)
+	if !ok {
+		return false, errors.New("error: value is not of type Chunk")
+	}
+	return c.Index == o.Index && bytes.Equal(c.Data, o.Data), nil
+}
+
+//NewTreeFromReader creates a new Merkle Tree whose leaves are the consecutive chunks of chunkSize
+//bytes read from r until io.EOF; the last chunk may be shorter. The chunks are hashed with the
+//hash strategy of the tree, sha256 unless set by opts. The tree keeps the data of every chunk so
+//that it can be served along with its proof by ChunkProof. Returns an error if r is empty.
+func NewTreeFromReader(r io.Reader, chunkSize int, opts ...Option) (*MerkleTree, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("error: invalid chunk size %d", chunkSize)
+	}
+	c := newConfig(sha256.New, opts)
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	var cs []Content
+	for {
+		data := make([]byte, chunkSize)
+		n, err := io.ReadFull(r, data)
+		if n > 0 {
+			cs = append(cs, Chunk{Index: len(cs), Data: data[:n], hashStrategy: c.hashStrategy})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error: reading chunk %d: %w", len(cs), err)
+		}
+	}
+	return NewTreeWithOptions(cs, opts...)
+}
+
+//ChunkProof returns the chunk at index together with its inclusion proof, so that a downloader
+//can check each chunk against the root of the blob as it arrives using Proof.VerifyChunk.
+//Returns an error if the leaf at index does not hold a Chunk.
+func (m *Tree[T]) ChunkProof(index int) (Chunk, *Proof, error) {
+	if m.store != nil {
+		return Chunk{}, nil, errNodeStore
+	}
+	p, err := m.GenerateProof(index)
+	if err != nil {
+		return Chunk{}, nil, err
+	}
+	c, ok := interface{}(m.Leafs[index].C).(Chunk)
+	if !ok {
+		return Chunk{}, nil, fmt.Errorf("error: leaf %d does not hold a chunk", index)
+	}
+	return c, p, nil
+}
+
+//VerifyChunk checks that data is the chunk at position LeafIndex of the blob of treeSize chunks
+//with Merkle Root root. The data is hashed with the hash algorithm of the proof.
+func (p *Proof) VerifyChunk(root []byte, treeSize int, data []byte) (bool, error) {
+	return p.VerifyContent(root, treeSize, Chunk{Index: p.LeafIndex, Data: data, hashStrategy: p.HashAlgorithm.New})
+}
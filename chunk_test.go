@@ -0,0 +1,139 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+//blob returns n bytes of deterministic test data.
+func blob(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	return data
+}
+
+func TestNewTreeFromReader(t *testing.T) {
+	for _, tc := range []struct {
+		size, chunkSize, chunks int
+	}{
+		{1, 4, 1},
+		{16, 4, 4},
+		{17, 4, 5},
+		{1000, 64, 16},
+		{1000, 1000, 1},
+		{1000, 4096, 1},
+	} {
+		data := blob(tc.size)
+		tree, err := NewTreeFromReader(iotest.HalfReader(bytes.NewReader(data)), tc.chunkSize, WithDomainSeparation())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.leafCount() != tc.chunks {
+			t.Fatalf("[%d/%d] error: expected %d chunks got %d", tc.size, tc.chunkSize, tc.chunks, tree.leafCount())
+		}
+		var cs []Content
+		for i := 0; i*tc.chunkSize < tc.size; i++ {
+			end := (i + 1) * tc.chunkSize
+			if end > tc.size {
+				end = tc.size
+			}
+			cs = append(cs, TestSHA256Content{x: string(data[i*tc.chunkSize : end])})
+		}
+		expected, err := NewTreeWithOptions(cs, WithDomainSeparation())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+			t.Errorf("[%d/%d] error: expected root %x got %x", tc.size, tc.chunkSize, expected.MerkleRoot(), tree.MerkleRoot())
+		}
+
+		//A downloader verifies every chunk against the root as it arrives.
+		var received []byte
+		for i := 0; i < tc.chunks; i++ {
+			chunk, p, err := tree.ChunkProof(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chunk.Index != i {
+				t.Errorf("[%d/%d] error: expected chunk %d got %d", tc.size, tc.chunkSize, i, chunk.Index)
+			}
+			ok, err := p.VerifyChunk(tree.MerkleRoot(), tree.Len(), chunk.Data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Errorf("[%d/%d] error: expected chunk %d to verify", tc.size, tc.chunkSize, i)
+			}
+			tampered := append([]byte{1}, chunk.Data[1:]...)
+			tampered[0] = chunk.Data[0] ^ 1
+			if ok, err := p.VerifyChunk(tree.MerkleRoot(), tree.Len(), tampered); err != nil || ok {
+				t.Errorf("[%d/%d] error: expected tampered chunk %d to fail: %v", tc.size, tc.chunkSize, i, err)
+			}
+			received = append(received, chunk.Data...)
+		}
+		if !bytes.Equal(received, data) {
+			t.Errorf("[%d/%d] error: expected chunks to reassemble the blob", tc.size, tc.chunkSize)
+		}
+	}
+}
+
+func TestNewTreeFromReader_HashStrategy(t *testing.T) {
+	data := blob(100)
+	tree, err := NewTreeFromReader(bytes.NewReader(data), 10, WithHashStrategy(md5.New))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree.Leafs[0].Hash) != md5.Size {
+		t.Errorf("error: expected md5 leaf hashes got %d bytes", len(tree.Leafs[0].Hash))
+	}
+	chunk, _, err := tree.ChunkProof(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := tree.VerifyContent(chunk); err != nil || !ok {
+		t.Errorf("error: expected chunk to verify: %v", err)
+	}
+	if ok, err := tree.VerifyContent(Chunk{Index: 3, Data: data[30:40]}); err != nil || ok {
+		t.Errorf("error: expected chunk hashed with sha256 not to match: %v", err)
+	}
+	_, p, err := tree.ChunkProof(9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := p.VerifyChunk(tree.MerkleRoot(), tree.Len(), data[90:]); err != nil || !ok {
+		t.Errorf("error: expected last chunk to verify: %v", err)
+	}
+}
+
+func TestNewTreeFromReader_Errors(t *testing.T) {
+	if _, err := NewTreeFromReader(bytes.NewReader(nil), 10); err == nil {
+		t.Error("error: expected error for empty reader")
+	}
+	if _, err := NewTreeFromReader(bytes.NewReader(blob(10)), 0); err == nil {
+		t.Error("error: expected error for chunk size 0")
+	}
+	broken := io.MultiReader(bytes.NewReader(blob(25)), iotest.ErrReader(io.ErrClosedPipe))
+	if _, err := NewTreeFromReader(broken, 10); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("error: expected read error got %v", err)
+	}
+	if _, err := NewTreeFromReader(bytes.NewReader(blob(10)), 10, WithHashStrategy(nil)); !errors.Is(err, ErrNilHashStrategy) {
+		t.Errorf("error: expected ErrNilHashStrategy got %v", err)
+	}
+	tree, err := NewTreeWithHashStrategy(table[0].contents, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tree.ChunkProof(0); err == nil {
+		t.Error("error: expected error for a leaf without a chunk")
+	}
+}
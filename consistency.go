@@ -0,0 +1,176 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+//ErrConsistencyPolicy is returned when a consistency proof is requested for a tree that does not
+//have the RFC 6962 shape. Under OddLeafDuplicate the root of a smaller tree is not a subtree of a
+//larger one, so consistency cannot be proven.
+var ErrConsistencyPolicy = errors.New("error: consistency proofs require OddLeafPromote or OddLeafRFC6962")
+
+//ConsistencyProof returns the RFC 6962 consistency proof that the tree built from the first
+//oldSize leaves is a prefix of the tree built from the first newSize leaves. Together with the
+//roots of both trees it lets an auditor confirm, using VerifyConsistency, that the newer tree only
+//appended to the older one.
+func (m *Tree[T]) ConsistencyProof(oldSize, newSize int) ([][]byte, error) {
+	if m.oddLeafPolicy == OddLeafDuplicate {
+		return nil, ErrConsistencyPolicy
+	}
+	if oldSize <= 0 || oldSize > newSize || newSize > m.leafCount() {
+		return nil, fmt.Errorf("error: invalid consistency range %d to %d for tree of %d leaves", oldSize, newSize, m.leafCount())
+	}
+	return m.subproof(oldSize, 0, newSize, true)
+}
+
+//RootAt returns the Merkle Root of the tree built from the first size leaves. Only available
+//for trees with the RFC 6962 shape.
+func (m *Tree[T]) RootAt(size int) ([]byte, error) {
+	if m.oddLeafPolicy == OddLeafDuplicate {
+		return nil, ErrConsistencyPolicy
+	}
+	if size <= 0 || size > m.leafCount() {
+		return nil, fmt.Errorf("error: invalid tree size %d for tree of %d leaves", size, m.leafCount())
+	}
+	return m.subtreeHash(0, size)
+}
+
+//subproof implements SUBPROOF of RFC 6962 section 2.1.2 for the leaves [lo, hi), where the old
+//tree covers the first oldSize of them.
+func (m *Tree[T]) subproof(oldSize, lo, hi int, complete bool) ([][]byte, error) {
+	n := hi - lo
+	if oldSize == n {
+		if complete {
+			return nil, nil
+		}
+		hash, err := m.subtreeHash(lo, hi)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{hash}, nil
+	}
+	k := splitPoint(n)
+	if oldSize <= k {
+		proof, err := m.subproof(oldSize, lo, lo+k, complete)
+		if err != nil {
+			return nil, err
+		}
+		hash, err := m.subtreeHash(lo+k, hi)
+		if err != nil {
+			return nil, err
+		}
+		return append(proof, hash), nil
+	}
+	proof, err := m.subproof(oldSize-k, lo+k, hi, false)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := m.subtreeHash(lo, lo+k)
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, hash), nil
+}
+
+//subtreeHash returns the Merkle Tree Hash of the leaves [lo, hi). Complete subtrees are read from
+//the levels of the tree; only the right edge of a range that ends inside a subtree is rehashed.
+func (m *Tree[T]) subtreeHash(lo, hi int) ([]byte, error) {
+	n := hi - lo
+	if n&(n-1) == 0 {
+		k := 0
+		for 1<<uint(k) < n {
+			k++
+		}
+		if lo%n == 0 {
+			return m.nodeHash(k, lo>>uint(k))
+		}
+	}
+	k := splitPoint(n)
+	left, err := m.subtreeHash(lo, lo+k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := m.subtreeHash(lo+k, hi)
+	if err != nil {
+		return nil, err
+	}
+	return m.hashChildren(left, right)
+}
+
+//splitPoint returns the largest power of two smaller than n, for n > 1.
+func splitPoint(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+//VerifyConsistency checks that proof, as returned by ConsistencyProof, shows that the tree of
+//oldSize leaves with root oldRoot is a prefix of the tree of newSize leaves with root newRoot.
+//Both trees must have been built with hashStrategy and the RFC 6962 shape; options that change
+//how the tree is hashed, such as WithDomainSeparation, must match the ones used to build them.
+func VerifyConsistency(oldSize, newSize int, oldRoot, newRoot []byte, proof [][]byte, hashStrategy func() hash.Hash, opts ...Option) (bool, error) {
+	if hashStrategy == nil {
+		return false, ErrNilHashStrategy
+	}
+	if oldSize <= 0 || oldSize > newSize {
+		return false, fmt.Errorf("error: invalid consistency range %d to %d", oldSize, newSize)
+	}
+	c := newConfig(hashStrategy, opts)
+	size := hashStrategy().Size()
+	if len(oldRoot) != size || len(newRoot) != size {
+		return false, fmt.Errorf("%w: roots are %d and %d bytes, expected %d", ErrInvalidHashSize, len(oldRoot), len(newRoot), size)
+	}
+	for i, p := range proof {
+		if len(p) != size {
+			return false, fmt.Errorf("%w: proof hash %d is %d bytes, expected %d", ErrInvalidHashSize, i, len(p), size)
+		}
+	}
+	if oldSize == newSize {
+		return len(proof) == 0 && bytes.Equal(oldRoot, newRoot), nil
+	}
+	if len(proof) == 0 {
+		return false, nil
+	}
+
+	//The verification algorithm of RFC 9162 section 2.1.4.2.
+	if oldSize&(oldSize-1) == 0 {
+		proof = append([][]byte{oldRoot}, proof...)
+	}
+	fn, sn := oldSize-1, newSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	var err error
+	for _, p := range proof[1:] {
+		if sn == 0 {
+			return false, nil
+		}
+		if fn&1 == 1 || fn == sn {
+			if fr, err = c.hashChildren(p, fr); err != nil {
+				return false, err
+			}
+			if sr, err = c.hashChildren(p, sr); err != nil {
+				return false, err
+			}
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else if sr, err = c.hashChildren(sr, p); err != nil {
+			return false, err
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, oldRoot) && bytes.Equal(sr, newRoot), nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+//rfc6962ConsistencyProofs are consistency proofs between prefixes of rfc6962Leaves from the
+//reference test vectors of the Certificate Transparency implementations.
+var rfc6962ConsistencyProofs = []struct {
+	oldSize, newSize int
+	proof            []string
+}{
+	{1, 1, nil},
+	{1, 8, []string{
+		"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+	}},
+	{6, 8, []string{
+		"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+		"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	}},
+	{2, 5, []string{
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+	}},
+}
+
+func TestMerkleTree_ConsistencyProofRFC6962(t *testing.T) {
+	tree, err := NewTreeWithOptions(rfc6962Leaves, WithDomainSeparation(), WithOddLeafPolicy(OddLeafRFC6962))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range rfc6962ConsistencyProofs {
+		proof, err := tree.ConsistencyProof(tc.oldSize, tc.newSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(proof) != len(tc.proof) {
+			t.Fatalf("[%d:%d] error: expected %d hashes got %d", tc.oldSize, tc.newSize, len(tc.proof), len(proof))
+		}
+		for i := range proof {
+			if hex.EncodeToString(proof[i]) != tc.proof[i] {
+				t.Errorf("[%d:%d] error: expected hash %d to be %s got %x", tc.oldSize, tc.newSize, i, tc.proof[i], proof[i])
+			}
+		}
+		oldRoot := mustDecodeHex(rfc6962Roots[tc.oldSize-1])
+		newRoot := mustDecodeHex(rfc6962Roots[tc.newSize-1])
+		ok, err := VerifyConsistency(tc.oldSize, tc.newSize, oldRoot, newRoot, proof, sha256.New, WithDomainSeparation())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("[%d:%d] error: expected consistency proof to verify", tc.oldSize, tc.newSize)
+		}
+	}
+}
+
+func TestMerkleTree_ConsistencyProof(t *testing.T) {
+	for _, domainSeparation := range []bool{false, true} {
+		opts := []Option{WithOddLeafPolicy(OddLeafPromote)}
+		if domainSeparation {
+			opts = append(opts, WithDomainSeparation())
+		}
+		cs := numberedContents(17)
+		tree, err := NewTreeWithOptions(cs, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for newSize := 1; newSize <= len(cs); newSize++ {
+			newTree, err := NewTreeWithOptions(cs[:newSize], opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			newRoot, err := tree.RootAt(newSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(newRoot, newTree.MerkleRoot()) {
+				t.Errorf("[%t size:%d] error: expected root %x got %x", domainSeparation, newSize, newTree.MerkleRoot(), newRoot)
+			}
+			for oldSize := 1; oldSize <= newSize; oldSize++ {
+				oldRoot, err := tree.RootAt(oldSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				proof, err := tree.ConsistencyProof(oldSize, newSize)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ok, err := VerifyConsistency(oldSize, newSize, oldRoot, newRoot, proof, sha256.New, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					t.Errorf("[%t %d:%d] error: expected consistency proof to verify", domainSeparation, oldSize, newSize)
+				}
+				if oldSize == newSize {
+					continue
+				}
+				//A rewritten history must not verify.
+				forged := append([]byte{}, oldRoot...)
+				forged[0] ^= 1
+				ok, err = VerifyConsistency(oldSize, newSize, forged, newRoot, proof, sha256.New, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok {
+					t.Errorf("[%t %d:%d] error: expected forged old root to fail", domainSeparation, oldSize, newSize)
+				}
+				tampered := append([][]byte{}, proof...)
+				tampered[len(tampered)-1] = forged
+				ok, err = VerifyConsistency(oldSize, newSize, oldRoot, newRoot, tampered, sha256.New, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ok {
+					t.Errorf("[%t %d:%d] error: expected tampered proof to fail", domainSeparation, oldSize, newSize)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleTree_ConsistencyProofErrors(t *testing.T) {
+	tree, err := NewTree(table[0].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tree.ConsistencyProof(1, 4); !errors.Is(err, ErrConsistencyPolicy) {
+		t.Errorf("error: expected ErrConsistencyPolicy got %v", err)
+	}
+	tree, err = NewTreeWithOptions(table[0].contents, WithOddLeafPolicy(OddLeafRFC6962))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range [][2]int{{0, 4}, {3, 2}, {1, 5}} {
+		if _, err := tree.ConsistencyProof(r[0], r[1]); err == nil {
+			t.Errorf("[%d:%d] error: expected error for invalid range", r[0], r[1])
+		}
+	}
+	proof, err := tree.ConsistencyProof(1, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.MerkleRoot()
+	if _, err := VerifyConsistency(1, 4, root[:4], root, proof, sha256.New); !errors.Is(err, ErrInvalidHashSize) {
+		t.Errorf("error: expected ErrInvalidHashSize got %v", err)
+	}
+	if _, err := VerifyConsistency(1, 4, root, root, proof, nil); !errors.Is(err, ErrNilHashStrategy) {
+		t.Errorf("error: expected ErrNilHashStrategy got %v", err)
+	}
+}
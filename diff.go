@@ -0,0 +1,102 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/bits"
+	"reflect"
+)
+
+//TreeDiff holds the leaves that differ between two trees. Changed lists the indexes present in
+//both trees whose leaf hashes differ, OnlyA the indexes past the end of b and OnlyB the indexes
+//past the end of a. All three are in ascending order.
+type TreeDiff struct {
+	Changed []int
+	OnlyA   []int
+	OnlyB   []int
+}
+
+//Empty reports whether the trees have the same leaves.
+func (d *TreeDiff) Empty() bool {
+	return len(d.Changed) == 0 && len(d.OnlyA) == 0 && len(d.OnlyB) == 0
+}
+
+//Diff returns the leaves that differ between a and b by position. The node j of level k covers
+//the leaves [j*2^k, (j+1)*2^k) in every tree holding at least (j+1)*2^k leaves, so Diff compares
+//the hashes of such nodes, starting at the largest, and only descends into the ones that differ.
+//Finding d changed leaves among n reads O(d log n) hashes. Returns an error unless both trees hash
+//their leaves and nodes in the same way.
+func Diff[T any](a, b *Tree[T]) (*TreeDiff, error) {
+	if a.domainSeparation != b.domainSeparation {
+		return nil, errors.New("error: cannot diff trees with and without domain separation")
+	}
+	ha, hb := a.hashStrategy(), b.hashStrategy()
+	if reflect.TypeOf(ha) != reflect.TypeOf(hb) || ha.Size() != hb.Size() {
+		return nil, errors.New("error: cannot diff trees with different hash strategies")
+	}
+	na, nb := a.leafCount(), b.leafCount()
+	common := na
+	if nb < common {
+		common = nb
+	}
+	d := &TreeDiff{}
+	for i := common; i < na; i++ {
+		d.OnlyA = append(d.OnlyA, i)
+	}
+	for i := common; i < nb; i++ {
+		d.OnlyB = append(d.OnlyB, i)
+	}
+	if na == nb && bytes.Equal(a.MerkleRoot(), b.MerkleRoot()) {
+		return d, nil
+	}
+	var visit func(k, j int) error
+	visit = func(k, j int) error {
+		lo := j << uint(k)
+		if lo >= common {
+			return nil
+		}
+		if lo+1<<uint(k) <= common {
+			ha, err := a.nodeHash(k, j)
+			if err != nil {
+				return err
+			}
+			hb, err := b.nodeHash(k, j)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(ha, hb) {
+				return nil
+			}
+			if k == 0 {
+				d.Changed = append(d.Changed, j)
+				return nil
+			}
+		}
+		if err := visit(k-1, 2*j); err != nil {
+			return err
+		}
+		return visit(k-1, 2*j+1)
+	}
+	k := 0
+	for 1<<uint(k) < common {
+		k++
+	}
+	if err := visit(k, 0); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+//BlockHash returns the hash of the node j of level k, which covers the leaves [j*2^k, (j+1)*2^k).
+//The hash is the same in every tree with the same hashing holding at least (j+1)*2^k leaves,
+//whatever its odd-leaf policy. Returns an error if the tree holds fewer leaves.
+func (m *Tree[T]) BlockHash(k, j int) ([]byte, error) {
+	if k < 0 || k >= bits.Len(uint(m.leafCount())) || j < 0 || j >= m.leafCount()>>uint(k) {
+		return nil, fmt.Errorf("error: block %d of level %d is not complete in a tree of %d leaves", j, k, m.leafCount())
+	}
+	return m.nodeHash(k, j)
+}
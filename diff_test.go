@@ -0,0 +1,158 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/md5"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		for _, tc := range []struct {
+			sizeA, sizeB int
+			changed      []int
+		}{
+			{1, 1, nil},
+			{1, 1, []int{0}},
+			{8, 8, nil},
+			{8, 8, []int{0, 5, 7}},
+			{13, 13, []int{12}},
+			{13, 7, []int{2, 6}},
+			{7, 13, []int{0, 1, 2, 3, 4, 5, 6}},
+			{33, 32, []int{31}},
+			{5, 100, []int{4}},
+		} {
+			cs := numberedContents(tc.sizeA)
+			other := numberedContents(tc.sizeB)
+			for _, i := range tc.changed {
+				other[i] = TestSHA256Content{x: "changed"}
+			}
+			opts := []Option{WithOddLeafPolicy(policy), WithDomainSeparation()}
+			a, err := NewTreeWithOptions(cs, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := NewTreeWithOptions(other, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := Diff(a, b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var onlyA, onlyB []int
+			for i := tc.sizeB; i < tc.sizeA; i++ {
+				onlyA = append(onlyA, i)
+			}
+			for i := tc.sizeA; i < tc.sizeB; i++ {
+				onlyB = append(onlyB, i)
+			}
+			if !reflect.DeepEqual(d.Changed, tc.changed) || !reflect.DeepEqual(d.OnlyA, onlyA) || !reflect.DeepEqual(d.OnlyB, onlyB) {
+				t.Errorf("[%v %d/%d] error: expected %v %v %v got %v %v %v", policy, tc.sizeA, tc.sizeB, tc.changed, onlyA, onlyB, d.Changed, d.OnlyA, d.OnlyB)
+			}
+			if d.Empty() != (tc.sizeA == tc.sizeB && len(tc.changed) == 0) {
+				t.Errorf("[%v %d/%d] error: expected Empty to be %t", policy, tc.sizeA, tc.sizeB, !d.Empty())
+			}
+			reverse, err := Diff(b, a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(reverse.Changed, d.Changed) || !reflect.DeepEqual(reverse.OnlyA, d.OnlyB) || !reflect.DeepEqual(reverse.OnlyB, d.OnlyA) {
+				t.Errorf("[%v %d/%d] error: expected the reverse diff to swap sides", policy, tc.sizeA, tc.sizeB)
+			}
+		}
+	}
+}
+
+func TestDiff_AfterMutations(t *testing.T) {
+	cs := numberedContents(50)
+	a, err := NewTree(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewTree(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := b.UpdateLeaf(17, TestSHA256Content{x: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Append(TestSHA256Content{x: "appended"}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(d.Changed, []int{17}) || d.OnlyA != nil || !reflect.DeepEqual(d.OnlyB, []int{50}) {
+		t.Errorf("error: expected [17] [] [50] got %v %v %v", d.Changed, d.OnlyA, d.OnlyB)
+	}
+	if err := b.RemoveLeaf(0); err != nil {
+		t.Fatal(err)
+	}
+	d, err = Diff(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Changed) != 50 {
+		t.Errorf("error: expected every leaf to shift after removing the first, got %v", d.Changed)
+	}
+}
+
+func TestDiff_Errors(t *testing.T) {
+	a, err := NewTree(table[0].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewTreeWithHashStrategy(table[0].contents, md5.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Diff(a, b); err == nil {
+		t.Error("error: expected error for different hash strategies")
+	}
+	c, err := NewTreeWithOptions(table[0].contents, WithDomainSeparation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Diff(a, c); err == nil {
+		t.Error("error: expected error for different domain separation")
+	}
+}
+
+func TestMerkleTree_BlockHash(t *testing.T) {
+	var trees []*MerkleTree
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		tree, err := NewTreeWithOptions(numberedContents(11), WithOddLeafPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.Len() != 11 || tree.OddLeafPolicy() != policy || tree.DomainSeparation() || tree.HashAlgorithm() != crypto.SHA256 {
+			t.Errorf("[%v] error: expected the tree to report its settings", policy)
+		}
+		trees = append(trees, tree)
+	}
+	for _, b := range []struct{ k, j int }{{0, 0}, {0, 10}, {1, 4}, {2, 1}, {3, 0}} {
+		for _, tree := range trees {
+			hash, err := tree.BlockHash(b.k, b.j)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, _ := trees[0].BlockHash(b.k, b.j)
+			if !bytes.Equal(hash, expected) {
+				t.Errorf("[%v %d/%d] error: expected block hash to be independent of the policy", tree.OddLeafPolicy(), b.k, b.j)
+			}
+		}
+	}
+	for _, b := range []struct{ k, j int }{{0, 11}, {1, 5}, {2, 2}, {4, 0}, {-1, 0}, {0, -1}, {0, math.MaxInt}, {2, math.MaxInt >> 1}} {
+		if _, err := trees[0].BlockHash(b.k, b.j); err == nil {
+			t.Errorf("[%d/%d] error: expected error for an incomplete block", b.k, b.j)
+		}
+	}
+}
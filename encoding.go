@@ -0,0 +1,237 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//treeEncodingMagic starts every binary encoded MerkleTree.
+var treeEncodingMagic = [4]byte{'M', 'R', 'K', 'T'}
+
+//treeEncodingVersion is the version of the binary encoding written by MarshalBinary.
+const treeEncodingVersion = 1
+
+//Flags of the binary encoding.
+const (
+	flagDomainSeparation = 1 << iota
+	flagSorted
+)
+
+//maxEncodedLevels is the largest number of levels of an encoded tree, enough for 2^63 leaves.
+const maxEncodedLevels = 64
+
+//MarshalBinary implements encoding.BinaryMarshaler. Only hashes are encoded, not the Content of
+//the leaves, so trees whose structure depends on that content cannot be encoded: MarshalBinary
+//returns an error for trees built with WithSortKey, WithSubtreeFilters or WithNodeStore. All
+//integers are big endian and the layout of version 1 is:
+//
+//	magic           4 bytes "MRKT"
+//	version         uint8
+//	hash algorithm  uint32, the crypto.Hash of the hash strategy or 0 if it is not registered
+//	flags           uint8, bit 0 set if the tree uses domain separation, bit 1 set if its leaves
+//	                are sorted by hash with WithSortedLeaves; the other bits are zero
+//	odd leaf policy uint8
+//	hash size       uint16
+//	leaf count      uint64, not counting the duplicate padding leaf
+//	level count     uint32
+//	levels          for each level from the leaves to the root: a uint64 number of nodes
+//	                followed by the hash of each node
+func (m *Tree[T]) MarshalBinary() ([]byte, error) {
+	if m.store != nil {
+		return nil, errNodeStore
+	}
+	if m.sortKey != nil {
+		return nil, errors.New("error: a tree sorted by a sort key cannot be encoded without its content")
+	}
+	if m.subtreeKeys != nil {
+		return nil, errors.New("error: a tree with subtree filters cannot be encoded without its content")
+	}
+	size := m.hashStrategy().Size()
+	var buf bytes.Buffer
+	buf.Write(treeEncodingMagic[:])
+	buf.WriteByte(treeEncodingVersion)
+	var flags byte
+	if m.domainSeparation {
+		flags |= flagDomainSeparation
+	}
+	if m.sorted {
+		flags |= flagSorted
+	}
+	header := []interface{}{
+		uint32(hashAlgorithm(m.hashStrategy)),
+		flags,
+		uint8(m.oddLeafPolicy),
+		uint16(size),
+		uint64(m.leafCount()),
+		uint32(len(m.levels)),
+	}
+	for _, v := range header {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	for k, level := range m.levels {
+		if err := binary.Write(&buf, binary.BigEndian, uint64(len(level))); err != nil {
+			return nil, err
+		}
+		for j, n := range level {
+			if len(n.Hash) != size {
+				return nil, fmt.Errorf("%w: node %d of level %d", ErrInvalidHashSize, j, k)
+			}
+			buf.Write(n.Hash)
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+//UnmarshalBinary implements encoding.BinaryUnmarshaler. It restores Root, Leafs and the Parent
+//links from the encoded hashes without rehashing. The hash strategy is taken from the recorded
+//hash algorithm; a tree built with an unregistered hash strategy can only be decoded into a tree
+//that already has that strategy. The options recorded in the flags replace those of m, and its
+//sort key, subtree filters and node store are dropped. The decoded leaves hold no Content:
+//content lookups match them by hash alone, and VerifyTree checks the interior hashes against the
+//stored leaf hashes until the leaves are given content with UpdateLeaf.
+func (m *Tree[T]) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var magic [4]byte
+	var version uint8
+	if err := binary.Read(r, binary.BigEndian, &magic); err != nil || magic != treeEncodingMagic {
+		return errors.New("error: data is not an encoded merkle tree")
+	}
+	if err := binary.Read(r, binary.BigEndian, &version); err != nil {
+		return err
+	}
+	if version != treeEncodingVersion {
+		return fmt.Errorf("error: unsupported merkle tree encoding version %d", version)
+	}
+	var header struct {
+		HashAlgorithm uint32
+		Flags         uint8
+		OddLeafPolicy uint8
+		HashSize      uint16
+		LeafCount     uint64
+		LevelCount    uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return fmt.Errorf("error: truncated merkle tree header: %v", err)
+	}
+	if header.Flags&^(flagDomainSeparation|flagSorted) != 0 {
+		return fmt.Errorf("error: unknown merkle tree encoding flags %#x", header.Flags)
+	}
+
+	c := config{
+		hashStrategy:     m.hashStrategy,
+		parallelism:      m.parallelism,
+		sorted:           header.Flags&flagSorted != 0,
+		bloomFPRate:      m.bloomFPRate,
+		domainSeparation: header.Flags&flagDomainSeparation != 0,
+		oddLeafPolicy:    OddLeafPolicy(header.OddLeafPolicy),
+	}
+	if algorithm := crypto.Hash(header.HashAlgorithm); algorithm != 0 {
+		if !algorithm.Available() {
+			return fmt.Errorf("error: hash algorithm %v is not available", algorithm)
+		}
+		c.hashStrategy = algorithm.New
+	}
+	if err := c.validate(); err != nil {
+		return err
+	}
+	size := int(header.HashSize)
+	if c.hashStrategy().Size() != size {
+		return fmt.Errorf("%w: encoded hashes are %d bytes, hash strategy produces %d", ErrInvalidHashSize, size, c.hashStrategy().Size())
+	}
+
+	//Every level holds at least one node, so the remaining data bounds the number of levels.
+	if header.LevelCount > maxEncodedLevels || int(header.LevelCount) > r.Len()/(8+size) {
+		return fmt.Errorf("error: invalid level count %d", header.LevelCount)
+	}
+	levels := make([][]*NodeOf[T], 0, header.LevelCount)
+	for k := 0; k < int(header.LevelCount); k++ {
+		var width uint64
+		if err := binary.Read(r, binary.BigEndian, &width); err != nil {
+			return fmt.Errorf("error: truncated level %d: %v", k, err)
+		}
+		if width == 0 || width > uint64(r.Len()/size) {
+			return fmt.Errorf("error: invalid width %d for level %d", width, k)
+		}
+		level := make([]*NodeOf[T], width)
+		for j := range level {
+			hash := make([]byte, size)
+			if _, err := io.ReadFull(r, hash); err != nil {
+				return err
+			}
+			level[j] = &NodeOf[T]{Hash: hash, Tree: m, leaf: k == 0, empty: k == 0}
+		}
+		levels = append(levels, level)
+	}
+	if r.Len() != 0 {
+		return errors.New("error: trailing data after encoded merkle tree")
+	}
+	if err := linkLevels(levels, int(header.LeafCount), &c); err != nil {
+		return err
+	}
+	if err := m.initFuncs(); err != nil {
+		return err
+	}
+	m.config = c
+	if err := m.setLevels(levels); err != nil {
+		return err
+	}
+	m.reindexLeafs()
+	return nil
+}
+
+//linkLevels sets the Left, Right and Parent links between the decoded levels and checks that their
+//widths match a tree of leafCount contents under the odd-leaf policy of c.
+func linkLevels[T any](levels [][]*NodeOf[T], leafCount int, c *config) error {
+	if len(levels) == 0 || leafCount == 0 {
+		return errors.New("error: encoded merkle tree has no content")
+	}
+	leafs := levels[0]
+	switch {
+	case len(leafs) == leafCount:
+	case len(leafs) == leafCount+1 && leafCount%2 == 1 && c.oddLeafPolicy == OddLeafDuplicate:
+		dup := leafs[leafCount]
+		if !bytes.Equal(dup.Hash, leafs[leafCount-1].Hash) {
+			return errors.New("error: duplicate padding leaf does not match the last leaf")
+		}
+		dup.dup = true
+	default:
+		return fmt.Errorf("error: %d leaf hashes encoded for %d contents", len(leafs), leafCount)
+	}
+	if c.oddLeafPolicy == OddLeafDuplicate && len(leafs)%2 == 1 {
+		return fmt.Errorf("error: %d leaf hashes encoded for duplicate policy", len(leafs))
+	}
+	for k := 0; k+1 < len(levels); k++ {
+		nl, nodes := levels[k], levels[k+1]
+		if len(nl) == 1 || len(nodes) != (len(nl)+1)/2 {
+			return fmt.Errorf("error: level %d has %d nodes, expected %d", k+1, len(nodes), (len(nl)+1)/2)
+		}
+		for j, n := range nodes {
+			left, right := nl[2*j], nl[2*j]
+			if 2*j+1 < len(nl) {
+				right = nl[2*j+1]
+			} else if c.oddLeafPolicy != OddLeafDuplicate {
+				//The promoted node appears on both levels.
+				if !bytes.Equal(n.Hash, left.Hash) {
+					return fmt.Errorf("error: promoted node %d of level %d does not match", j, k+1)
+				}
+				nodes[j] = left
+				continue
+			}
+			n.Left, n.Right = left, right
+			left.Parent, right.Parent = n, n
+		}
+	}
+	if len(levels[len(levels)-1]) != 1 {
+		return errors.New("error: encoded merkle tree does not end in a single root")
+	}
+	return nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"hash"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*MerkleTree)(nil)
+	_ encoding.BinaryUnmarshaler = (*MerkleTree)(nil)
+)
+
+func TestMerkleTree_MarshalBinary(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		for _, domainSeparation := range []bool{false, true} {
+			for i := 0; i < len(table); i++ {
+				opts := []Option{WithHashStrategy(table[i].hashStrategy), WithOddLeafPolicy(policy)}
+				if domainSeparation {
+					opts = append(opts, WithDomainSeparation())
+				}
+				tree, err := NewTreeWithOptions(table[i].contents, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := tree.MarshalBinary()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var loaded MerkleTree
+				if err := loaded.UnmarshalBinary(data); err != nil {
+					t.Fatalf("[%v %t case:%d] error: unexpected error: %v", policy, domainSeparation, table[i].testCaseId, err)
+				}
+				if !bytes.Equal(loaded.MerkleRoot(), tree.MerkleRoot()) {
+					t.Errorf("[%v %t case:%d] error: expected root %x got %x", policy, domainSeparation, table[i].testCaseId, tree.MerkleRoot(), loaded.MerkleRoot())
+				}
+				if len(loaded.Leafs) != len(tree.Leafs) {
+					t.Errorf("[%v %t case:%d] error: expected %d leafs got %d", policy, domainSeparation, table[i].testCaseId, len(tree.Leafs), len(loaded.Leafs))
+				}
+				v, err := loaded.VerifyTree()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !v {
+					t.Errorf("[%v %t case:%d] error: expected loaded tree to be valid", policy, domainSeparation, table[i].testCaseId)
+				}
+				for j, c := range table[i].contents {
+					expected, err := tree.GenerateProof(j)
+					if err != nil {
+						t.Fatal(err)
+					}
+					p, err := loaded.GenerateProof(j)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(p.Hashes) != len(expected.Hashes) {
+						t.Fatalf("[%v %t case:%d] error: expected %d proof hashes got %d", policy, domainSeparation, table[i].testCaseId, len(expected.Hashes), len(p.Hashes))
+					}
+					for k := range p.Hashes {
+						if !bytes.Equal(p.Hashes[k], expected.Hashes[k]) {
+							t.Errorf("[%v %t case:%d] error: proof hash %d of leaf %d differs", policy, domainSeparation, table[i].testCaseId, k, j)
+						}
+					}
+					if ok, err := loaded.VerifyContent(c); err != nil || !ok {
+						t.Errorf("[%v %t case:%d] error: expected content %d to verify: %v", policy, domainSeparation, table[i].testCaseId, j, err)
+					}
+				}
+				//Giving the leaves their content makes VerifyTree recompute every hash again.
+				for j, c := range table[i].contents {
+					if _, _, err := loaded.UpdateLeaf(j, c); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if !bytes.Equal(loaded.MerkleRoot(), tree.MerkleRoot()) {
+					t.Errorf("[%v %t case:%d] error: expected root to survive adding content", policy, domainSeparation, table[i].testCaseId)
+				}
+				if err := loaded.RebuildTree(); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(loaded.MerkleRoot(), tree.MerkleRoot()) {
+					t.Errorf("[%v %t case:%d] error: expected root to survive rebuild", policy, domainSeparation, table[i].testCaseId)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleTree_UnmarshalBinaryDetectsTampering(t *testing.T) {
+	tree, err := NewTree(numberedContents(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Flip a bit in the last byte of the first interior node.
+	offset := len(treeEncodingMagic) + 1 + 4 + 1 + 1 + 2 + 8 + 4 + 8 + 8*sha256.Size + 8 + sha256.Size - 1
+	tampered := append([]byte{}, data...)
+	tampered[offset] ^= 1
+	var loaded MerkleTree
+	if err := loaded.UnmarshalBinary(tampered); err != nil {
+		t.Fatal(err)
+	}
+	v, err := loaded.VerifyTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v {
+		t.Error("error: expected tampered tree to be invalid")
+	}
+}
+
+func TestMerkleTree_MarshalBinaryOptions(t *testing.T) {
+	cs := sortedContents(9)
+	tree, err := NewTreeWithOptions(cs, WithSortedLeaves(), WithDomainSeparation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//The sorted flag replaces the options of the tree decoded into.
+	loaded, err := NewTreeWithOptions(cs, WithSortKey(contentKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.sorted || loaded.sortKey != nil || !bytes.Equal(loaded.MerkleRoot(), tree.MerkleRoot()) {
+		t.Error("error: expected the decoded tree to be sorted by hash")
+	}
+	//The neighbours of a non-inclusion proof need their content back.
+	absent := TestSHA256Content{x: "absent"}
+	if _, err := loaded.NonInclusionProof(absent); err == nil {
+		t.Error("error: expected error for neighbours without content")
+	}
+	for j := 0; j < tree.Len(); j++ {
+		if _, _, err := loaded.UpdateLeaf(j, tree.Leafs[j].C); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := loaded.NonInclusionProof(absent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := p.Verify(tree.MerkleRoot(), tree.Len(), absent, nil); err != nil || !ok {
+		t.Errorf("error: expected non-inclusion proof of the decoded tree to verify: %v", err)
+	}
+
+	for name, opt := range map[string]Option{
+		"sort key":        WithSortKey(contentKey),
+		"subtree filters": WithSubtreeFilters(64, 3, tagKeys),
+		"node store":      WithNodeStore(NewMemoryStore()),
+	} {
+		tree, err := NewTreeWithOptions(cs, opt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tree.MarshalBinary(); err == nil {
+			t.Errorf("[%s] error: expected error encoding a tree that cannot be decoded", name)
+		}
+	}
+}
+
+func TestMerkleTree_UnmarshalBinaryErrors(t *testing.T) {
+	tree, err := NewTree(numberedContents(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded MerkleTree
+	for name, bad := range map[string][]byte{
+		"empty":     nil,
+		"magic":     append([]byte("XXXX"), data[4:]...),
+		"version":   append(append(append([]byte{}, data[:4]...), 99), data[5:]...),
+		"truncated": data[:len(data)-1],
+		"trailing":  append(append([]byte{}, data...), 0),
+		"flags":     append(append(append([]byte{}, data[:9]...), 0x80), data[10:]...),
+		"levels":    append(append(append([]byte{}, data[:21]...), 0xff, 0xff, 0xff, 0xff), 0),
+	} {
+		if err := loaded.UnmarshalBinary(bad); err == nil {
+			t.Errorf("[%s] error: expected error", name)
+		}
+	}
+
+	calls := 0
+	custom := func() hash.Hash {
+		calls++
+		return sha256.New()
+	}
+	//The type of custom matches sha256, so wrap it to make the strategy unregistered.
+	unregistered := func() hash.Hash { return struct{ hash.Hash }{custom()} }
+	tree, err = NewTreeWithHashStrategy(table[0].contents, unregistered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zero MerkleTree
+	if err := zero.UnmarshalBinary(data); err == nil {
+		t.Error("error: expected error decoding an unregistered hash strategy without one")
+	}
+	other, err := NewTreeWithHashStrategy(table[1].contents, unregistered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(other.MerkleRoot(), tree.MerkleRoot()) {
+		t.Errorf("error: expected root %x got %x", tree.MerkleRoot(), other.MerkleRoot())
+	}
+}
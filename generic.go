@@ -0,0 +1,161 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+//valueFuncs hashes and compares the values of a Tree, and adapts them to Content for the
+//functions given to WithSortKey and WithSubtreeFilters and for search and non-inclusion proofs.
+type valueFuncs[T any] struct {
+	hash    func(T) ([]byte, error)
+	equal   func(a, b T) (bool, error)
+	content func(T) Content
+}
+
+//contentFuncs are the functions of the values of a MerkleTree: the methods of Content.
+var contentFuncs = &valueFuncs[Content]{
+	hash: func(c Content) ([]byte, error) {
+		if c == nil {
+			return nil, errors.New("error: content must not be nil")
+		}
+		return c.CalculateHash()
+	},
+	equal: func(a, b Content) (bool, error) {
+		return a.Equals(b)
+	},
+	content: func(c Content) Content {
+		return c
+	},
+}
+
+//initFuncs gives a tree that was not created by a constructor, such as a MerkleTree to decode
+//into, the functions of Content.
+func (m *Tree[T]) initFuncs() error {
+	if m.funcs != nil {
+		return nil
+	}
+	funcs, ok := interface{}(contentFuncs).(*valueFuncs[T])
+	if !ok {
+		return errors.New("error: tree has no hasher, create it with NewTreeOf or NewTreeOfFunc")
+	}
+	m.funcs = funcs
+	return nil
+}
+
+//leaf adapts a value of a Tree to Content. All leaves of a tree share its valueFuncs, so Equals
+//never sees a value of another type from that tree.
+type leaf[T any] struct {
+	value T
+	funcs *valueFuncs[T]
+}
+
+//CalculateHash returns the hash of the value.
+func (l leaf[T]) CalculateHash() ([]byte, error) {
+	return l.funcs.hash(l.value)
+}
+
+//Equals reports whether other holds an equal value. Content that is not a value of type T is
+//never equal.
+func (l leaf[T]) Equals(other Content) (bool, error) {
+	o, ok := other.(leaf[T])
+	if !ok {
+		return false, nil
+	}
+	if l.funcs.equal == nil {
+		return false, errors.New("error: values have no equality")
+	}
+	return l.funcs.equal(l.value, o.value)
+}
+
+//ValueOf returns the value held by content that a Tree[T] passed to a function given to
+//WithSortKey or WithSubtreeFilters, or put in a SearchMatch or NonInclusionProof. Returns false if
+//content does not hold a value of type T.
+func ValueOf[T any](content Content) (T, bool) {
+	l, ok := content.(leaf[T])
+	return l.value, ok
+}
+
+//NewTreeOf creates a Tree of the comparable values, hashed with hasher and compared with ==.
+func NewTreeOf[T comparable](values []T, hasher func(T) ([]byte, error), opts ...Option) (*Tree[T], error) {
+	return NewTreeOfFunc(values, hasher, func(a, b T) bool { return a == b }, opts...)
+}
+
+//NewTreeOfFunc creates a Tree of the values, hashed with hasher and compared with equal, so T
+//does not need to implement Content. The roots, paths and proofs of the tree are the same as
+//those of a MerkleTree of Content with the same hashes. Returns an error if hasher or equal is nil
+//or values is empty.
+func NewTreeOfFunc[T any](values []T, hasher func(T) ([]byte, error), equal func(a, b T) bool, opts ...Option) (*Tree[T], error) {
+	if hasher == nil {
+		return nil, errors.New("error: hasher must not be nil")
+	}
+	if equal == nil {
+		return nil, errors.New("error: equality must not be nil")
+	}
+	funcs := &valueFuncs[T]{
+		hash:  hasher,
+		equal: func(a, b T) (bool, error) { return equal(a, b), nil },
+	}
+	funcs.content = func(v T) Content {
+		return leaf[T]{value: v, funcs: funcs}
+	}
+	return newTree(values, funcs, opts)
+}
+
+//newTree creates a tree of the values, hashed and compared with funcs and configured by opts.
+func newTree[T any](values []T, funcs *valueFuncs[T], opts []Option) (*Tree[T], error) {
+	var defaultHashStrategy = sha256.New
+	t := &Tree[T]{
+		config: newConfig(defaultHashStrategy, opts),
+		funcs:  funcs,
+	}
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	if err := t.build(values); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+//Value returns the value at index.
+func (m *Tree[T]) Value(index int) (T, error) {
+	var zero T
+	if index < 0 || index >= m.leafCount() {
+		return zero, fmt.Errorf("error: leaf index %d out of range [0, %d)", index, m.leafCount())
+	}
+	if m.store != nil {
+		return zero, errNodeStore
+	}
+	if m.Leafs[index].empty {
+		return zero, fmt.Errorf("error: leaf %d has no content", index)
+	}
+	return m.Leafs[index].C, nil
+}
+
+//Values returns the values of the tree in leaf order.
+func (m *Tree[T]) Values() ([]T, error) {
+	values := make([]T, m.leafCount())
+	for i := range values {
+		v, err := m.Value(i)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+//VerifyValueProof checks that p proves v, hashed with hasher, sits at position p.LeafIndex of the
+//tree of treeSize values with Merkle Root root. It is the counterpart of Proof.VerifyContent for a
+//Tree.
+func VerifyValueProof[T any](p *Proof, root []byte, treeSize int, v T, hasher func(T) ([]byte, error)) (bool, error) {
+	if hasher == nil {
+		return false, errors.New("error: hasher must not be nil")
+	}
+	return p.VerifyContent(root, treeSize, leaf[T]{value: v, funcs: &valueFuncs[T]{hash: hasher}})
+}
@@ -0,0 +1,221 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+//hashString hashes s like TestSHA256Content does.
+func hashString(s string) ([]byte, error) {
+	h := sha256.Sum256([]byte(s))
+	return h[:], nil
+}
+
+func TestTree(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		values := []string{"a", "b", "c", "d", "e"}
+		opts := []Option{WithOddLeafPolicy(policy), WithDomainSeparation()}
+		tree, err := NewTreeOf(values, hashString, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cs []Content
+		for _, v := range values {
+			cs = append(cs, TestSHA256Content{x: v})
+		}
+		expected, err := NewTreeWithOptions(cs, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+			t.Errorf("[%v] error: expected the root of the equivalent Content tree", policy)
+		}
+		if got, err := tree.Values(); err != nil || !reflect.DeepEqual(got, values) {
+			t.Errorf("[%v] error: expected values %v got %v: %v", policy, values, got, err)
+		}
+		for i, v := range values {
+			p, err := tree.GenerateProof(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := VerifyValueProof(p, tree.MerkleRoot(), tree.Len(), v, hashString); err != nil || !ok {
+				t.Errorf("[%v] error: expected proof of %q to verify: %v", policy, v, err)
+			}
+			if ok, err := p.VerifyContent(tree.MerkleRoot(), tree.Len(), cs[i]); err != nil || !ok {
+				t.Errorf("[%v] error: expected proof of %q to verify as Content: %v", policy, v, err)
+			}
+			if ok, err := VerifyValueProof(p, tree.MerkleRoot(), tree.Len(), v+"!", hashString); err != nil || ok {
+				t.Errorf("[%v] error: expected proof of another value to fail: %v", policy, err)
+			}
+			path, indexes, err := tree.GetMerklePath(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expectedPath, expectedIndexes, _ := expected.GetMerklePath(cs[i])
+			if !reflect.DeepEqual(path, expectedPath) || !reflect.DeepEqual(indexes, expectedIndexes) {
+				t.Errorf("[%v] error: expected path of %q to match", policy, v)
+			}
+		}
+
+		if err := tree.Append("f", "a"); err != nil {
+			t.Fatal(err)
+		}
+		if indexes, err := tree.IndexesOf("a"); err != nil || !reflect.DeepEqual(indexes, []int{0, 6}) {
+			t.Errorf("[%v] error: expected a at [0 6] got %v: %v", policy, indexes, err)
+		}
+		if proofs, err := tree.GenerateProofs("a"); err != nil || len(proofs) != 2 {
+			t.Errorf("[%v] error: expected 2 proofs got %d: %v", policy, len(proofs), err)
+		}
+		if _, _, err := tree.UpdateLeaf(2, "z"); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := tree.RemoveContent("a"); err != nil || n != 2 {
+			t.Errorf("[%v] error: expected 2 values removed got %d: %v", policy, n, err)
+		}
+		if err := tree.RemoveLeaf(0); err != nil {
+			t.Fatal(err)
+		}
+		want := []string{"z", "d", "e", "f"}
+		if got, err := tree.Values(); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("[%v] error: expected values %v got %v: %v", policy, want, got, err)
+		}
+		rebuilt, err := NewTreeOf(want, hashString, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tree.MerkleRoot(), rebuilt.MerkleRoot()) || tree.Len() != 4 {
+			t.Errorf("[%v] error: expected mutated tree to match a new tree", policy)
+		}
+		if ok, err := tree.VerifyContent("z"); err != nil || !ok {
+			t.Errorf("[%v] error: expected z to verify: %v", policy, err)
+		}
+		if ok, err := tree.VerifyContent("a"); err != nil || ok {
+			t.Errorf("[%v] error: expected removed value not to verify: %v", policy, err)
+		}
+		if ok, err := tree.VerifyTree(); err != nil || !ok {
+			t.Errorf("[%v] error: expected tree to verify: %v", policy, err)
+		}
+	}
+}
+
+//record is not comparable, so it needs an equality function.
+type record struct {
+	id   int
+	tags []string
+}
+
+func TestTree_Func(t *testing.T) {
+	hasher := func(r record) ([]byte, error) {
+		h := sha256.New()
+		h.Write([]byte{byte(r.id)})
+		return h.Sum(nil), nil
+	}
+	equal := func(a, b record) bool {
+		return a.id == b.id
+	}
+	records := []record{{1, []string{"x"}}, {2, nil}, {3, []string{"y", "z"}}}
+	tree, err := NewTreeOfFunc(records, hasher, equal, WithSortedLeaves(), WithSortKey(func(c Content) ([]byte, error) {
+		r, ok := ValueOf[record](c)
+		if !ok {
+			return nil, errors.New("error: content is not a record")
+		}
+		return []byte{byte(10 - r.id)}, nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := tree.Value(0)
+	if err != nil || first.id != 3 || !reflect.DeepEqual(first.tags, []string{"y", "z"}) {
+		t.Errorf("error: expected sorted tree to start with record 3 got %v: %v", first, err)
+	}
+	if indexes, err := tree.IndexesOf(record{id: 1}); err != nil || !reflect.DeepEqual(indexes, []int{2}) {
+		t.Errorf("error: expected record 1 at [2] got %v: %v", indexes, err)
+	}
+	if _, ok := ValueOf[string](tree.funcs.content(first)); ok {
+		t.Error("error: expected a record not to unwrap as a string")
+	}
+	if _, ok := ValueOf[record](TestSHA256Content{x: "a"}); ok {
+		t.Error("error: expected other Content not to unwrap")
+	}
+}
+
+func TestTree_Errors(t *testing.T) {
+	if _, err := NewTreeOf([]string{"a"}, nil); err == nil {
+		t.Error("error: expected error for nil hasher")
+	}
+	if _, err := NewTreeOfFunc([]string{"a"}, hashString, nil); err == nil {
+		t.Error("error: expected error for nil equality")
+	}
+	if _, err := NewTreeOf([]string{}, hashString); err == nil {
+		t.Error("error: expected error for no values")
+	}
+	failing := func(s string) ([]byte, error) {
+		if s == "bad" {
+			return nil, errFailingContent
+		}
+		return hashString(s)
+	}
+	var leafErr *LeafHashError
+	if _, err := NewTreeOf([]string{"a", "bad"}, failing); !errors.As(err, &leafErr) || leafErr.Index != 1 || !errors.Is(err, errFailingContent) {
+		t.Errorf("error: expected LeafHashError for value 1 got %v", err)
+	}
+	tree, err := NewTreeOf([]string{"a", "b"}, failing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tree.UpdateLeaf(0, "bad"); !errors.Is(err, errFailingContent) {
+		t.Errorf("error: expected hasher error got %v", err)
+	}
+	for _, i := range []int{-1, 2} {
+		if _, err := tree.Value(i); err == nil {
+			t.Errorf("[index:%d] error: expected error for index out of range", i)
+		}
+	}
+	p, err := tree.GenerateProof(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyValueProof(p, tree.MerkleRoot(), tree.Len(), "a", nil); err == nil {
+		t.Error("error: expected error for nil hasher")
+	}
+	stored, err := NewTreeOf([]string{"a", "b"}, hashString, WithNodeStore(NewMemoryStore()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stored.Value(0); !errors.Is(err, errNodeStore) {
+		t.Errorf("error: expected errNodeStore got %v", err)
+	}
+	data, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var zero Tree[string]
+	if err := zero.UnmarshalBinary(data); err == nil {
+		t.Error("error: expected error decoding into a tree without a hasher")
+	}
+	decoded, err := NewTreeOf([]string{"x"}, hashString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := decoded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decoded.Value(0); err == nil {
+		t.Error("error: expected error for a decoded leaf without a value")
+	}
+	if err := decoded.RebuildTree(); err == nil {
+		t.Error("error: expected error rebuilding leaves without values")
+	}
+	if _, _, err := decoded.UpdateLeaf(0, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := decoded.Value(0); err != nil || v != "a" {
+		t.Errorf("error: expected value a got %q: %v", v, err)
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/cbergoon/merkletree
 
-go 1.12
+go 1.18
@@ -5,10 +5,12 @@ package merkletree
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"crypto"
 	"errors"
 	"fmt"
 	"hash"
+	"math"
+	"sort"
 )
 
 //Content represents the data that is stored and verified by the tree. A type that
@@ -18,34 +20,230 @@ type Content interface {
 	Equals(other Content) (bool, error)
 }
 
-
-//MerkleTree is the container for the tree. It holds a pointer to the root of the tree,
+//MerkleTree is the container for a tree of Content. It holds a pointer to the root of the tree,
 //a list of pointers to the leaf nodes, and the merkle root.
-type MerkleTree struct {
-	Root         *Node
-	merkleRoot   []byte
-	Leafs        []*Node
-	hashStrategy func() hash.Hash
-}
-
-//Node represents a node, root, or leaf in the tree. It stores pointers to its immediate
-//relationships, a hash, the content stored if it is a leaf, and other metadata.
-type Node struct {
-	Tree   *MerkleTree
-	Parent *Node
-	Left   *Node
-	Right  *Node
-	leaf   bool
-	dup    bool
-	Hash   []byte
-	C      Content
+type MerkleTree = Tree[Content]
+
+//Tree is the container for a tree of values of type T. It holds a pointer to the root of the
+//tree, a list of pointers to the leaf nodes, and the merkle root. The values are hashed and
+//compared with the functions given to NewTreeOf or NewTreeOfFunc; a MerkleTree uses the methods
+//of Content.
+type Tree[T any] struct {
+	Root          *NodeOf[T]
+	merkleRoot    []byte
+	Leafs         []*NodeOf[T]
+	levels        [][]*NodeOf[T]
+	leafIndex     map[string][]int
+	bloom         *BloomFilter
+	bloomCapacity int
+	storedLeafs   int
+	funcs         *valueFuncs[T]
+	config
+}
+
+//Option configures a tree created with NewTreeWithOptions.
+type Option func(*config)
+
+//config holds the settings that determine how the nodes of a tree are hashed.
+type config struct {
+	hashStrategy        func() hash.Hash
+	domainSeparation    bool
+	oddLeafPolicy       OddLeafPolicy
+	parallelism         int
+	sorted              bool
+	sortKey             func(Content) ([]byte, error)
+	bloomFPRate         float64
+	subtreeKeys         func(Content) ([][]byte, error)
+	subtreeFilterBits   int
+	subtreeFilterHashes int
+	store               NodeStore
+}
+
+//OddLeafPolicy determines how the last node of a level with an odd number of nodes is combined.
+type OddLeafPolicy int
+
+const (
+	//OddLeafDuplicate pairs the last node with a copy of itself, as Bitcoin does. This is the
+	//default. Note that a list of contents and the same list with its last content repeated
+	//produce the same root (CVE-2012-2459).
+	OddLeafDuplicate OddLeafPolicy = iota
+	//OddLeafPromote moves the last node up to the next level unchanged.
+	OddLeafPromote
+	//OddLeafRFC6962 splits the leaves at the largest power of two smaller than their number, as
+	//described in RFC 6962. Promoting lone nodes bottom up yields exactly this left-heavy tree, so
+	//it is built like OddLeafPromote; combined with WithDomainSeparation and sha256 the root is the
+	//RFC 6962 Merkle Tree Hash.
+	OddLeafRFC6962
+)
+
+//String returns the name of the policy.
+func (p OddLeafPolicy) String() string {
+	switch p {
+	case OddLeafDuplicate:
+		return "duplicate"
+	case OddLeafPromote:
+		return "promote"
+	case OddLeafRFC6962:
+		return "rfc6962"
+	}
+	return fmt.Sprintf("OddLeafPolicy(%d)", int(p))
+}
+
+//WithHashStrategy sets the hash strategy used to combine nodes. Note that the hash type used in
+//the type that implements the Content interface must match the hash type provided to the tree.
+func WithHashStrategy(hashStrategy func() hash.Hash) Option {
+	return func(c *config) {
+		c.hashStrategy = hashStrategy
+	}
+}
+
+//WithDomainSeparation prefixes leaf hashes with 0x00 and interior hashes with 0x01 as described
+//in RFC 6962 (Certificate Transparency), so that an interior node can never be passed off as a
+//leaf. A leaf node then holds hash(0x00 || CalculateHash()) and an interior node holds
+//hash(0x01 || left || right).
+func WithDomainSeparation() Option {
+	return func(c *config) {
+		c.domainSeparation = true
+	}
+}
+
+//WithOddLeafPolicy sets how the last node of a level with an odd number of nodes is combined.
+func WithOddLeafPolicy(policy OddLeafPolicy) Option {
+	return func(c *config) {
+		c.oddLeafPolicy = policy
+	}
+}
+
+//newConfig returns the configuration for hashStrategy with opts applied.
+func newConfig(hashStrategy func() hash.Hash, opts []Option) config {
+	c := config{hashStrategy: hashStrategy}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+//validate returns an error if the configuration cannot be used to hash a tree.
+func (c *config) validate() error {
+	if c.hashStrategy == nil {
+		return ErrNilHashStrategy
+	}
+	switch c.oddLeafPolicy {
+	case OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962:
+	default:
+		return fmt.Errorf("error: unknown odd leaf policy %v", c.oddLeafPolicy)
+	}
+	if c.bloomFPRate != 0 && !(c.bloomFPRate > 0 && c.bloomFPRate < 1) {
+		return fmt.Errorf("error: invalid bloom filter false positive rate %v", c.bloomFPRate)
+	}
+	if c.subtreeKeys != nil && (c.subtreeFilterBits <= 0 || int64(c.subtreeFilterBits) > math.MaxUint32 ||
+		c.subtreeFilterHashes <= 0 || c.subtreeFilterHashes > maxBloomHashes) {
+		return fmt.Errorf("error: invalid subtree filters of %d bits and %d hashes", c.subtreeFilterBits, c.subtreeFilterHashes)
+	}
+	if c.store != nil && (c.sorted || c.bloomFPRate != 0 || c.subtreeKeys != nil) {
+		return errors.New("error: a node store cannot be combined with sorted leaves or filters")
+	}
+	return nil
+}
+
+//Prefixes used to separate leaf and interior hashes when domain separation is enabled.
+const (
+	leafPrefix     byte = 0x00
+	interiorPrefix byte = 0x01
+)
+
+//hashLeaf returns the hash stored in a leaf node for content hash contentHash.
+func (c *config) hashLeaf(contentHash []byte) ([]byte, error) {
+	if !c.domainSeparation {
+		return contentHash, nil
+	}
+	h := c.hashStrategy()
+	if _, err := h.Write(append([]byte{leafPrefix}, contentHash...)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+//hashContent returns the hash stored in a leaf node holding content.
+func (c *config) hashContent(content Content) ([]byte, error) {
+	if content == nil {
+		return nil, errors.New("error: content must not be nil")
+	}
+	contentHash, err := content.CalculateHash()
+	if err != nil {
+		return nil, err
+	}
+	return c.hashLeaf(contentHash)
 }
 
+//hashValue returns the hash stored in a leaf node holding v.
+func (m *Tree[T]) hashValue(v T) ([]byte, error) {
+	valueHash, err := m.funcs.hash(v)
+	if err != nil {
+		return nil, err
+	}
+	return m.hashLeaf(valueHash)
+}
+
+//hashChildren returns the hash of an interior node with children hashes left and right.
+func (c *config) hashChildren(left, right []byte) ([]byte, error) {
+	var chash []byte
+	if c.domainSeparation {
+		chash = append(chash, interiorPrefix)
+	}
+	chash = append(append(chash, left...), right...)
+	h := c.hashStrategy()
+	if _, err := h.Write(chash); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+//Node represents a node, root, or leaf in a MerkleTree.
+type Node = NodeOf[Content]
+
+//NodeOf represents a node, root, or leaf in a Tree. It stores pointers to its immediate
+//relationships, a hash, the value stored if it is a leaf, and other metadata. Leaves decoded by
+//UnmarshalBinary are empty: they hold no value, only their hash.
+type NodeOf[T any] struct {
+	Tree       *Tree[T]
+	Parent     *NodeOf[T]
+	Left       *NodeOf[T]
+	Right      *NodeOf[T]
+	leaf       bool
+	dup        bool
+	empty      bool
+	Hash       []byte
+	C          T
+	filter     *BloomFilter
+	filterHash []byte
+}
+
+//errNodeMismatch is returned by verifyNode when the stored hash of a node differs from the
+//hash calculated from its content or children.
+var errNodeMismatch = errors.New("error: node hash does not match its children")
+
 //verifyNode walks down the tree until hitting a leaf, calculating the hash at each level
-//and returning the resulting hash of Node n.
-func (n *Node) verifyNode() ([]byte, error) {
+//and returning the resulting hash of Node n. Leaves without content, as decoded by
+//UnmarshalBinary, contribute their stored hash.
+func (n *NodeOf[T]) verifyNode() ([]byte, error) {
 	if n.leaf {
-		return n.C.CalculateHash()
+		if n.empty {
+			return n.Hash, nil
+		}
+		hash, err := n.Tree.hashValue(n.C)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(hash, n.Hash) {
+			return nil, errNodeMismatch
+		}
+		if n.Tree.subtreeKeys != nil {
+			if err := n.verifyFilter(); err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
 	}
 	rightBytes, err := n.Right.verifyNode()
 	if err != nil {
@@ -57,201 +255,623 @@ func (n *Node) verifyNode() ([]byte, error) {
 		return nil, err
 	}
 
-	h := n.Tree.hashStrategy()
-	if _, err := h.Write(append(leftBytes, rightBytes...)); err != nil {
+	hash, err := n.Tree.hashChildren(leftBytes, rightBytes)
+	if err != nil {
 		return nil, err
 	}
-
-	return h.Sum(nil), nil
+	if !bytes.Equal(hash, n.Hash) {
+		return nil, errNodeMismatch
+	}
+	if n.Tree.subtreeKeys != nil {
+		if err := n.verifyFilter(); err != nil {
+			return nil, err
+		}
+	}
+	return hash, nil
 }
 
 // calculateNodeHash is a helper function that calculates the hash of the node.
-func (n *Node) calculateNodeHash() ([]byte, error) {
+func (n *NodeOf[T]) calculateNodeHash() ([]byte, error) {
 	if n.leaf {
-		return n.C.CalculateHash()
-	}
-	h := n.Tree.hashStrategy()
-	if _, err := h.Write(append(n.Left.Hash, n.Right.Hash...)); err != nil {
-		return nil, err
+		if n.empty {
+			return n.Hash, nil
+		}
+		return n.Tree.hashValue(n.C)
 	}
-	return h.Sum(nil), nil
+	return n.Tree.hashChildren(n.Left.Hash, n.Right.Hash)
 }
 
 //NewTree creates a new Merkle Tree using the content cs.
 func NewTree(cs []Content) (*MerkleTree, error) {
-	var defaultHashStrategy = sha256.New
-	t := &MerkleTree{
-		hashStrategy: defaultHashStrategy,
-	}
-	root, leafs, err := buildWithContent(cs, t)
-	if err != nil {
-		return nil, err
-	}
-	t.Root = root
-	t.Leafs = leafs
-	t.merkleRoot = root.Hash
-	return t, nil
+	return NewTreeWithOptions(cs)
 }
 
 //NewTreeWithHashStrategy creates a new Merkle Tree using the content cs using the provided hash
 //strategy. Note that the hash type used in the type that implements the Content interface must
 //match the hash type profided to the tree.
 func NewTreeWithHashStrategy(cs []Content, hashStrategy func() hash.Hash) (*MerkleTree, error) {
-	t := &MerkleTree{
-		hashStrategy: hashStrategy,
+	return NewTreeWithOptions(cs, WithHashStrategy(hashStrategy))
+}
+
+//NewTreeWithOptions creates a new Merkle Tree using the content cs configured by opts. Without
+//options the tree is identical to one created by NewTree.
+func NewTreeWithOptions(cs []Content, opts ...Option) (*MerkleTree, error) {
+	return newTree(cs, contentFuncs, opts)
+}
+
+//build replaces the content of the tree with cs and indexes the leaves by hash. The tree is
+//left unchanged if an error is returned.
+func (m *Tree[T]) build(cs []T) error {
+	if err := m.initFuncs(); err != nil {
+		return err
+	}
+	if m.store != nil {
+		return m.buildStored(cs)
 	}
-	root, leafs, err := buildWithContent(cs, t)
+	if err := buildWithContent(cs, m); err != nil {
+		return err
+	}
+	m.reindexLeafs()
+	return nil
+}
+
+//reindexLeafs rebuilds the index from leaf hash to leaf positions and the Bloom filter.
+func (m *Tree[T]) reindexLeafs() {
+	m.leafIndex = make(map[string][]int, len(m.Leafs))
+	for i, l := range m.Leafs[:m.leafCount()] {
+		m.leafIndex[string(l.Hash)] = append(m.leafIndex[string(l.Hash)], i)
+	}
+	m.rebuildBloom()
+}
+
+//setLevels makes levels, ordered from the leaves to the root, the nodes of the tree. The tree is
+//left unchanged if an error is returned.
+func (m *Tree[T]) setLevels(levels [][]*NodeOf[T]) error {
+	root := levels[len(levels)-1][0]
+	merkleRoot, err := m.rootHash(root)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	m.levels = levels
+	m.Leafs = levels[0]
+	m.Root = root
+	m.merkleRoot = merkleRoot
+	return nil
+}
+
+//rootHash returns the Merkle Root of a tree with root node n: the hash of n, bound to its filter
+//commitment if the tree has subtree filters.
+func (m *Tree[T]) rootHash(n *NodeOf[T]) ([]byte, error) {
+	if m.subtreeKeys == nil {
+		return n.Hash, nil
 	}
-	t.Root = root
-	t.Leafs = leafs
-	t.merkleRoot = root.Hash
-	return t, nil
+	return m.bindFilterRoot(n.Hash, n.filterHash)
 }
 
-// GetMerklePath: Get Merkle path and indexes(left leaf or right leaf)
-func (m *MerkleTree) GetMerklePath(content Content) ([][]byte, []int64, error) {
-	for _, current := range m.Leafs {
-		ok, err := current.C.Equals(content)
+//rebuild makes the tree the one whose leaves are the leaves of levels[0] before position start
+//followed by leafs, rebuilding only the nodes that depend on leafs and keeping the other nodes of
+//levels. The tree is left unchanged if an error is returned.
+func (m *Tree[T]) rebuild(levels [][]*NodeOf[T], start int, leafs []*NodeOf[T]) error {
+	tails, starts, err := buildIntermediate(levels, start, leafs, m)
+	if err != nil {
+		return err
+	}
+	//The root is kept if no leaf was added after position start.
+	top := len(tails) - 1
+	var root *NodeOf[T]
+	if starts[top] == 0 {
+		root = tails[top][0]
+	} else {
+		root = levels[top][0]
+	}
+	merkleRoot, err := m.rootHash(root)
+	if err != nil {
+		return err
+	}
+	levels = joinLevels(levels, tails, starts, m.oddLeafPolicy)
+	m.levels = levels
+	m.Leafs = levels[0]
+	m.Root = root
+	m.merkleRoot = merkleRoot
+	return nil
+}
+
+//Append adds the contents cs as new leaves after the existing ones. Only the nodes on the right
+//edge of the tree and the new subtrees are hashed, so appending k contents to a tree of n leaves
+//costs O(k + log n) hashes instead of a full rebuild. The resulting tree is identical to one built
+//from all of the content at once. In a sorted tree the contents are inserted at their sorted
+//positions instead and the tree is rehashed from the first of them. The tree is left unchanged if
+//an error is returned.
+func (m *Tree[T]) Append(cs ...T) error {
+	if len(cs) == 0 {
+		return nil
+	}
+	if m.store != nil {
+		return errNodeStore
+	}
+	n := m.leafCount()
+	leafs, err := buildLeafs(cs, n, m)
+	if err != nil {
+		return err
+	}
+	if m.sorted {
+		return m.appendSorted(leafs)
+	}
+	if err := m.rebuild(m.levels, n, leafs); err != nil {
+		return err
+	}
+	for i, l := range leafs {
+		m.leafIndex[string(l.Hash)] = append(m.leafIndex[string(l.Hash)], n+i)
+		m.addToBloom(l.Hash)
+	}
+	return nil
+}
+
+//UpdateLeaf replaces the content of the leaf at index with c and rehashes only the ancestors of
+//that leaf. Under OddLeafDuplicate the duplicate padding leaf is updated along with the last leaf.
+//Returns the Merkle Root before and after the update. In a sorted tree ErrUnsorted is returned if
+//the key of c does not fit between the keys of the neighbouring leaves.
+func (m *Tree[T]) UpdateLeaf(index int, c T) ([]byte, []byte, error) {
+	if index < 0 || index >= m.leafCount() {
+		return nil, nil, fmt.Errorf("error: leaf index %d out of range [0, %d)", index, m.leafCount())
+	}
+	if m.store != nil {
+		return nil, nil, errNodeStore
+	}
+	hash, err := m.hashValue(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m.sorted {
+		if err := m.checkOrder(index, c, hash); err != nil {
+			return nil, nil, err
+		}
+	}
+	var filter *BloomFilter
+	if m.subtreeKeys != nil {
+		if filter, err = m.leafFilter(m.funcs.content(c)); err != nil {
+			return nil, nil, err
+		}
+	}
+	oldRoot := m.merkleRoot
+	leaf := m.Leafs[index]
+	m.unindexLeaf(leaf.Hash, index)
+	m.indexLeaf(hash, index)
+	leaf.C, leaf.empty = c, false
+	leaf.Hash = hash
+	leaf.filter = filter
+	if filter != nil {
+		if err := m.annotateNode(leaf); err != nil {
+			return nil, nil, err
+		}
+	}
+	if index+1 < len(m.Leafs) && m.Leafs[index+1].dup {
+		m.Leafs[index+1].C, m.Leafs[index+1].empty = c, false
+		m.Leafs[index+1].Hash = hash
+		m.Leafs[index+1].filter, m.Leafs[index+1].filterHash = leaf.filter, leaf.filterHash
+	}
+	m.addToBloom(hash)
+	for parent := leaf.Parent; parent != nil; parent = parent.Parent {
+		parent.Hash, err = m.hashChildren(parent.Left.Hash, parent.Right.Hash)
 		if err != nil {
 			return nil, nil, err
 		}
+		if filter != nil {
+			if err := m.annotateNode(parent); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if m.merkleRoot, err = m.rootHash(m.Root); err != nil {
+		return nil, nil, err
+	}
+	return oldRoot, m.merkleRoot, nil
+}
 
+//RemoveLeaf removes the leaf at index. The leaves after it move one position to the left and
+//the tree is restructured from that position up, adding or dropping the duplicate padding leaf as
+//needed, so the result is identical to a new tree built from the remaining content. Returns an
+//error if index is out of range or the leaf is the only one in the tree.
+func (m *Tree[T]) RemoveLeaf(index int) error {
+	if index < 0 || index >= m.leafCount() {
+		return fmt.Errorf("error: leaf index %d out of range [0, %d)", index, m.leafCount())
+	}
+	return m.removeLeafs([]int{index})
+}
+
+//RemoveContent removes every leaf holding content and returns the number of leaves removed.
+//Returns an error if that would leave the tree without content.
+func (m *Tree[T]) RemoveContent(content T) (int, error) {
+	indexes, err := m.indexesOf(content, false)
+	if err != nil || len(indexes) == 0 {
+		return 0, err
+	}
+	if err := m.removeLeafs(indexes); err != nil {
+		return 0, err
+	}
+	return len(indexes), nil
+}
+
+//removeLeafs removes the leaves at the ascending positions indexes and rebuilds the tree from
+//the first of them.
+func (m *Tree[T]) removeLeafs(indexes []int) error {
+	if m.store != nil {
+		return errNodeStore
+	}
+	n := m.leafCount()
+	if len(indexes) >= n {
+		return errors.New("error: cannot remove all content from tree")
+	}
+	level := make([]*NodeOf[T], 0, n-len(indexes)+1)
+	next := 0
+	for _, i := range indexes {
+		level = append(level, m.Leafs[next:i]...)
+		next = i + 1
+	}
+	level = append(level, m.Leafs[next:n]...)
+	levels := append([][]*NodeOf[T]{level}, m.levels[1:]...)
+	if err := m.rebuild(levels, indexes[0], level[indexes[0]:]); err != nil {
+		return err
+	}
+	m.reindexLeafs()
+	return nil
+}
+
+//indexLeaf records that the leaf at position i has hash, keeping the positions for a hash sorted.
+func (m *Tree[T]) indexLeaf(hash []byte, i int) {
+	indexes := m.leafIndex[string(hash)]
+	at := sort.SearchInts(indexes, i)
+	indexes = append(indexes, 0)
+	copy(indexes[at+1:], indexes[at:])
+	indexes[at] = i
+	m.leafIndex[string(hash)] = indexes
+}
+
+//unindexLeaf removes position i from the positions recorded for hash.
+func (m *Tree[T]) unindexLeaf(hash []byte, i int) {
+	indexes := m.leafIndex[string(hash)]
+	at := sort.SearchInts(indexes, i)
+	if at == len(indexes) || indexes[at] != i {
+		return
+	}
+	if len(indexes) == 1 {
+		delete(m.leafIndex, string(hash))
+		return
+	}
+	m.leafIndex[string(hash)] = append(indexes[:at:at], indexes[at+1:]...)
+}
+
+// GetMerklePath: Get Merkle path and indexes(left leaf or right leaf) of the first leaf holding content
+func (m *Tree[T]) GetMerklePath(content T) ([][]byte, []int64, error) {
+	indexes, err := m.indexesOf(content, true)
+	if err != nil || len(indexes) == 0 {
+		return nil, nil, err
+	}
+	merklePath, index := m.merklePath(indexes[0])
+	return merklePath, index, nil
+}
+
+//GetMerklePathByIndex returns the merkle path and indexes of the leaf at position i. Unlike
+//GetMerklePath the side of each sibling is taken from the position of the node, so the result is
+//correct even when both children of a node have the same hash.
+func (m *Tree[T]) GetMerklePathByIndex(i int) ([][]byte, []int64, error) {
+	if i < 0 || i >= m.leafCount() {
+		return nil, nil, fmt.Errorf("error: leaf index %d out of range [0, %d)", i, m.leafCount())
+	}
+	if m.store != nil {
+		return m.storedPath(i)
+	}
+	merklePath, index := m.merklePath(i)
+	return merklePath, index, nil
+}
+
+//IndexesOf returns the positions of all leaves holding content, in ascending order.
+func (m *Tree[T]) IndexesOf(content T) ([]int, error) {
+	return m.indexesOf(content, false)
+}
+
+//LeafIndex returns the position of the first leaf whose node hash is hash. For trees built with
+//WithDomainSeparation the node hash is the prefixed hash, not the result of CalculateHash.
+func (m *Tree[T]) LeafIndex(hash []byte) (int, bool) {
+	indexes := m.leafIndex[string(hash)]
+	if len(indexes) == 0 {
+		return 0, false
+	}
+	return indexes[0], true
+}
+
+//HasLeafHash reports whether the tree holds a leaf whose node hash is hash.
+func (m *Tree[T]) HasLeafHash(hash []byte) bool {
+	return len(m.leafIndex[string(hash)]) > 0
+}
+
+//indexesOf returns the positions of the leaves holding content in ascending order, stopping at
+//the first one if first is set. The leaf hash index narrows the search so that Equals is only
+//called on leaves whose hash matches the hash of content.
+func (m *Tree[T]) indexesOf(content T, first bool) ([]int, error) {
+	if m.store != nil {
+		return nil, errNodeStore
+	}
+	hash, err := m.hashValue(content)
+	if err != nil {
+		return nil, err
+	}
+	if m.bloom != nil && !m.bloom.MayContain(hash) {
+		return nil, nil
+	}
+	var indexes []int
+	for _, i := range m.leafIndex[string(hash)] {
+		//Leaves decoded without content can only be matched by hash.
+		ok := m.Leafs[i].empty
+		if !ok {
+			if ok, err = m.funcs.equal(m.Leafs[i].C, content); err != nil {
+				return nil, err
+			}
+		}
 		if ok {
-			currentParent := current.Parent
-			var merklePath [][]byte
-			var index []int64
-			for currentParent != nil {
-				if bytes.Equal(currentParent.Left.Hash, current.Hash) {
-					merklePath = append(merklePath, currentParent.Right.Hash)
-					index = append(index, 1) // right leaf
-				} else {
-					merklePath = append(merklePath, currentParent.Left.Hash)
-					index = append(index, 0) // left leaf
-				}
-				current = currentParent
-				currentParent = currentParent.Parent
+			indexes = append(indexes, i)
+			if first {
+				break
 			}
-			return merklePath, index, nil
 		}
 	}
-	return nil, nil, nil
+	return indexes, nil
+}
+
+//merklePath walks from the leaf at position i up to the root and returns the sibling hashes and
+//indexes (1 if the sibling is the right child, 0 if it is the left child) along the way.
+func (m *Tree[T]) merklePath(i int) ([][]byte, []int64) {
+	var merklePath [][]byte
+	var index []int64
+	current := m.Leafs[i]
+	for parent := current.Parent; parent != nil; parent = parent.Parent {
+		if parent.Left == current {
+			merklePath = append(merklePath, parent.Right.Hash)
+			index = append(index, 1) // right leaf
+		} else {
+			merklePath = append(merklePath, parent.Left.Hash)
+			index = append(index, 0) // left leaf
+		}
+		current = parent
+	}
+	return merklePath, index
 }
 
 //buildWithContent is a helper function that for a given set of Contents, generates a
-//corresponding tree and returns the root node, a list of leaf nodes, and a possible error.
-//Returns an error if cs contains no Contents.
+//corresponding tree and makes it the tree t. Returns an error if cs contains no Contents.
 // Content 构建 MerkelTree
-func buildWithContent(cs []Content, t *MerkleTree) (*Node, []*Node, error) {
+func buildWithContent[T any](cs []T, t *Tree[T]) error {
 	if len(cs) == 0 {
-		return nil, nil, errors.New("error: cannot construct tree with no content")
+		return errors.New("error: cannot construct tree with no content")
 	}
-	var leafs []*Node
-	for _, c := range cs {
-		hash, err := c.CalculateHash()
-		if err != nil {
-			return nil, nil, err
-		}
-
-		leafs = append(leafs, &Node{
-			Hash: hash,
-			C:    c,
-			leaf: true,
-			Tree: t,
-		})
+	leafs, err := buildLeafs(cs, 0, t)
+	if err != nil {
+		return err
 	}
-	if len(leafs)%2 == 1 {
-		duplicate := &Node{
-			Hash: leafs[len(leafs)-1].Hash,
-			C:    leafs[len(leafs)-1].C,
-			leaf: true,
-			dup:  true,
-			Tree: t,
+	if t.sorted {
+		if _, err := t.sortLeafs(leafs); err != nil {
+			return err
 		}
-		leafs = append(leafs, duplicate)
 	}
-	root, err := buildIntermediate(leafs, t)
+	return t.rebuild(nil, 0, leafs)
+}
+
+//buildLeafs is a helper function that hashes the contents cs into leaf nodes of tree t. The
+//leaves are placed at position offset onwards, which is used to report the index of a leaf that
+//cannot be hashed.
+func buildLeafs[T any](cs []T, offset int, t *Tree[T]) ([]*NodeOf[T], error) {
+	leafs := make([]*NodeOf[T], len(cs), len(cs)+1)
+	err := t.parallelFor(len(cs), func(lo, hi int) error {
+		for i := lo; i < hi; i++ {
+			hash, err := t.hashValue(cs[i])
+			if err != nil {
+				return &LeafHashError{Index: offset + i, Err: err}
+			}
+
+			leafs[i] = &NodeOf[T]{
+				Hash: hash,
+				C:    cs[i],
+				leaf: true,
+				Tree: t,
+			}
+			if t.subtreeKeys != nil {
+				if leafs[i].filter, err = t.leafFilter(t.funcs.content(cs[i])); err != nil {
+					return &LeafHashError{Index: offset + i, Err: err}
+				}
+				if err := t.annotateNode(leafs[i]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
+	return leafs, nil
+}
 
-	return root, leafs, nil
+//duplicateLeaf is a helper function that returns the padding leaf duplicating leaf l, which
+//the odd-leaf policy OddLeafDuplicate appends to an odd number of leaves.
+func duplicateLeaf[T any](l *NodeOf[T], t *Tree[T]) *NodeOf[T] {
+	return &NodeOf[T]{
+		Hash:       l.Hash,
+		C:          l.C,
+		leaf:       true,
+		dup:        true,
+		empty:      l.empty,
+		Tree:       t,
+		filter:     l.filter,
+		filterHash: l.filterHash,
+	}
 }
 
-//buildIntermediate is a helper function that for a given list of leaf nodes, constructs
-//the intermediate and root levels of the tree. Returns the resulting root node of the tree.
-func buildIntermediate(nl []*Node, t *MerkleTree) (*Node, error) {
-	var nodes []*Node
-	for i := 0; i < len(nl); i += 2 {
-		h := t.hashStrategy()
-		var left, right int = i, i + 1
-		if i+1 == len(nl) {
-			right = i
+//buildIntermediate is a helper function that constructs the nodes of a tree whose leaves are
+//the leaves of levels[0] before position start followed by leafs. Nodes of levels that only
+//depend on leaves before position start are kept, every other node is rebuilt. Returns the new
+//nodes of each level from the leaf nodes to the root node, tails[k] replacing the nodes of level
+//k from position starts[k] on. Neither levels nor their nodes are modified, so building the new
+//nodes costs O(len(leafs) + log n) whatever the size of the kept levels.
+func buildIntermediate[T any](levels [][]*NodeOf[T], start int, leafs []*NodeOf[T], t *Tree[T]) ([][]*NodeOf[T], []int, error) {
+	width := start + len(leafs)
+	if width%2 == 1 && t.oddLeafPolicy == OddLeafDuplicate {
+		var last *NodeOf[T]
+		if len(leafs) > 0 {
+			last = leafs[len(leafs)-1]
+		} else {
+			last = levels[0][start-1]
 		}
-		chash := append(nl[left].Hash, nl[right].Hash...)
-		if _, err := h.Write(chash); err != nil {
-			return nil, err
+		leafs = append(leafs[:len(leafs):len(leafs)], duplicateLeaf(last, t))
+		width++
+	}
+	tails, starts := [][]*NodeOf[T]{leafs}, []int{start}
+	for k := 0; width > 1; k++ {
+		tail, from := tails[k], starts[k]
+		node := func(i int) *NodeOf[T] {
+			if i < from {
+				return levels[k][i]
+			}
+			return tail[i-from]
 		}
-		n := &Node{
-			Left:  nl[left],
-			Right: nl[right],
-			Hash:  h.Sum(nil),
-			Tree:  t,
+		keep := from / 2
+		nodes := make([]*NodeOf[T], (width+1)/2-keep)
+		err := t.parallelFor(len(nodes), func(lo, hi int) error {
+			for i := lo; i < hi; i++ {
+				var left, right int = 2 * (keep + i), 2*(keep+i) + 1
+				if right == width {
+					if t.oddLeafPolicy != OddLeafDuplicate {
+						nodes[i] = node(left)
+						continue
+					}
+					right = left
+				}
+				hash, err := t.hashChildren(node(left).Hash, node(right).Hash)
+				if err != nil {
+					return err
+				}
+				n := &NodeOf[T]{
+					Left:  node(left),
+					Right: node(right),
+					Hash:  hash,
+					Tree:  t,
+				}
+				if t.subtreeKeys != nil {
+					if err := t.annotateNode(n); err != nil {
+						return err
+					}
+				}
+				nodes[i] = n
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, nil, err
 		}
-		nodes = append(nodes, n)
-		nl[left].Parent = n
-		nl[right].Parent = n
-		if len(nl) == 2 {
-			return n, nil
+		tails, starts = append(tails, nodes), append(starts, keep)
+		width = (width + 1) / 2
+	}
+	return tails, starts, nil
+}
+
+//joinLevels is a helper function that replaces the nodes of each level of levels from position
+//starts[k] on with tails[k], as returned by buildIntermediate, and links the new nodes to their
+//parents. The levels grow in place, so only the replaced nodes are written. Returns the levels of
+//the tree from the leaf nodes to the root node.
+func joinLevels[T any](levels, tails [][]*NodeOf[T], starts []int, policy OddLeafPolicy) [][]*NodeOf[T] {
+	joined := make([][]*NodeOf[T], len(tails))
+	for k, tail := range tails {
+		var level []*NodeOf[T]
+		if k < len(levels) {
+			level = levels[k][:starts[k]]
+		}
+		joined[k] = append(level, tail...)
+	}
+	for k := 1; k < len(joined); k++ {
+		below := joined[k-1]
+		for i, n := range tails[k] {
+			left := 2 * (starts[k] + i)
+			if left+1 == len(below) && policy != OddLeafDuplicate {
+				continue
+			}
+			below[left].Parent = n
+			if left+1 < len(below) {
+				below[left+1].Parent = n
+			}
 		}
 	}
-	return buildIntermediate(nodes, t)
+	joined[len(joined)-1][0].Parent = nil
+	return joined
 }
 
-//MerkleRoot returns the unverified Merkle Root (hash of the root node) of the tree.
-func (m *MerkleTree) MerkleRoot() []byte {
+//MerkleRoot returns the unverified Merkle Root (hash of the root node) of the tree. For trees with
+//subtree filters the root is bound to FilterRoot as described in WithSubtreeFilters.
+func (m *Tree[T]) MerkleRoot() []byte {
 	return m.merkleRoot
 }
 
+//Len returns the number of contents in the tree, not counting the duplicate leaf added by
+//OddLeafDuplicate.
+func (m *Tree[T]) Len() int {
+	return m.leafCount()
+}
+
+//HashAlgorithm returns the crypto.Hash matching the hash strategy of the tree, or 0 if the
+//strategy is not a registered crypto.Hash.
+func (m *Tree[T]) HashAlgorithm() crypto.Hash {
+	return hashAlgorithm(m.hashStrategy)
+}
+
+//DomainSeparation reports whether the tree was created with WithDomainSeparation.
+func (m *Tree[T]) DomainSeparation() bool {
+	return m.domainSeparation
+}
+
+//OddLeafPolicy returns the odd-leaf policy the tree was created with.
+func (m *Tree[T]) OddLeafPolicy() OddLeafPolicy {
+	return m.oddLeafPolicy
+}
+
 //RebuildTree is a helper function that will rebuild the tree reusing only the content that
 //it holds in the leaves.
-func (m *MerkleTree) RebuildTree() error {
-	var cs []Content
-	for _, c := range m.Leafs {
-		cs = append(cs, c.C)
+func (m *Tree[T]) RebuildTree() error {
+	if m.store != nil {
+		return errNodeStore
 	}
-	root, leafs, err := buildWithContent(cs, m)
-	if err != nil {
-		return err
+	var cs []T
+	for i, l := range m.Leafs[:m.leafCount()] {
+		if l.empty {
+			return fmt.Errorf("error: leaf %d has no content", i)
+		}
+		cs = append(cs, l.C)
 	}
-	m.Root = root
-	m.Leafs = leafs
-	m.merkleRoot = root.Hash
-	return nil
+	return m.build(cs)
 }
 
 //RebuildTreeWith replaces the content of the tree and does a complete rebuild; while the root of
 //the tree will be replaced the MerkleTree completely survives this operation. Returns an error if the
 //list of content cs contains no entries.
-func (m *MerkleTree) RebuildTreeWith(cs []Content) error {
-	root, leafs, err := buildWithContent(cs, m)
-	if err != nil {
-		return err
-	}
-	m.Root = root
-	m.Leafs = leafs
-	m.merkleRoot = root.Hash
-	return nil
+func (m *Tree[T]) RebuildTreeWith(cs []T) error {
+	return m.build(cs)
 }
 
 //VerifyTree verify tree validates the hashes at each level of the tree and returns true if the
 //resulting hash at the root of the tree matches the resulting root hash; returns false otherwise.
-func (m *MerkleTree) VerifyTree() (bool, error) {
+func (m *Tree[T]) VerifyTree() (bool, error) {
+	if m.store != nil {
+		return m.verifyStored()
+	}
 	calculatedMerkleRoot, err := m.Root.verifyNode()
+	if err == errNodeMismatch {
+		return false, nil
+	}
 	if err != nil {
 		return false, err
 	}
+	if m.subtreeKeys != nil {
+		if calculatedMerkleRoot, err = m.bindFilterRoot(calculatedMerkleRoot, m.Root.filterHash); err != nil {
+			return false, err
+		}
+	}
 
 	if bytes.Compare(m.merkleRoot, calculatedMerkleRoot) == 0 {
 		return true, nil
@@ -262,49 +882,43 @@ func (m *MerkleTree) VerifyTree() (bool, error) {
 //VerifyContent indicates whether a given content is in the tree and the hashes are valid for that content.
 //Returns true if the expected Merkle Root is equivalent to the Merkle root calculated on the critical path
 //for a given content. Returns true if valid and false otherwise.
-func (m *MerkleTree) VerifyContent(content Content) (bool, error) {
-	for _, l := range m.Leafs {
-		ok, err := l.C.Equals(content)
+func (m *Tree[T]) VerifyContent(content T) (bool, error) {
+	indexes, err := m.indexesOf(content, true)
+	if err != nil || len(indexes) == 0 {
+		return false, err
+	}
+	currentParent := m.Leafs[indexes[0]].Parent
+	for currentParent != nil {
+		rightBytes, err := currentParent.Right.calculateNodeHash()
 		if err != nil {
 			return false, err
 		}
 
-		if ok {
-			currentParent := l.Parent
-			for currentParent != nil {
-				h := m.hashStrategy()
-				rightBytes, err := currentParent.Right.calculateNodeHash()
-				if err != nil {
-					return false, err
-				}
-
-				leftBytes, err := currentParent.Left.calculateNodeHash()
-				if err != nil {
-					return false, err
-				}
+		leftBytes, err := currentParent.Left.calculateNodeHash()
+		if err != nil {
+			return false, err
+		}
 
-				if _, err := h.Write(append(leftBytes, rightBytes...)); err != nil {
-					return false, err
-				}
-				if bytes.Compare(h.Sum(nil), currentParent.Hash) != 0 {
-					return false, nil
-				}
-				currentParent = currentParent.Parent
-			}
-			return true, nil
+		hash, err := m.hashChildren(leftBytes, rightBytes)
+		if err != nil {
+			return false, err
 		}
+		if bytes.Compare(hash, currentParent.Hash) != 0 {
+			return false, nil
+		}
+		currentParent = currentParent.Parent
 	}
-	return false, nil
+	return true, nil
 }
 
 //String returns a string representation of the node.
-func (n *Node) String() string {
-	return fmt.Sprintf("%t %t %v %s", n.leaf, n.dup, n.Hash, n.C)
+func (n *NodeOf[T]) String() string {
+	return fmt.Sprintf("%t %t %v %v", n.leaf, n.dup, n.Hash, n.C)
 }
 
 //String returns a string representation of the tree. Only leaf nodes are included
 //in the output.
-func (m *MerkleTree) String() string {
+func (m *Tree[T]) String() string {
 	s := ""
 	for _, l := range m.Leafs {
 		s += fmt.Sprint(l)
@@ -7,7 +7,11 @@ import (
 	"bytes"
 	"crypto/md5"
 	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"hash"
+	"runtime"
+	"strconv"
 	"testing"
 )
 
@@ -589,3 +593,717 @@ func TestMerkleTree_MerklePath(t *testing.T) {
 		}
 	}
 }
+
+//TestRawContent implements the Content interface with a CalculateHash that returns the raw
+//bytes unchanged, so the tree hashes leaf data the way RFC 6962 does.
+type TestRawContent struct {
+	x []byte
+}
+
+//CalculateHash returns the raw bytes of a TestRawContent
+func (t TestRawContent) CalculateHash() ([]byte, error) {
+	return t.x, nil
+}
+
+//Equals tests for equality of two Contents
+func (t TestRawContent) Equals(other Content) (bool, error) {
+	return bytes.Equal(t.x, other.(TestRawContent).x), nil
+}
+
+func mustDecodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+//rfc6962Leaves are the leaf inputs of the reference test vectors published with the Certificate
+//Transparency implementations of RFC 6962.
+var rfc6962Leaves = []Content{
+	TestRawContent{x: mustDecodeHex("")},
+	TestRawContent{x: mustDecodeHex("00")},
+	TestRawContent{x: mustDecodeHex("10")},
+	TestRawContent{x: mustDecodeHex("2021")},
+	TestRawContent{x: mustDecodeHex("3031")},
+	TestRawContent{x: mustDecodeHex("40414243")},
+	TestRawContent{x: mustDecodeHex("5051525354555657")},
+	TestRawContent{x: mustDecodeHex("606162636465666768696a6b6c6d6e6f")},
+}
+
+//rfc6962Roots holds the expected root for the first i+1 leaves of rfc6962Leaves.
+var rfc6962Roots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+func TestNewTreeWithOptions_DomainSeparationRFC6962(t *testing.T) {
+	//Without an odd-leaf policy only trees whose size is a power of two match RFC 6962.
+	for _, n := range []int{2, 4, 8} {
+		tree, err := NewTreeWithOptions(rfc6962Leaves[:n], WithDomainSeparation())
+		if err != nil {
+			t.Fatalf("[size:%d] error: unexpected error: %v", n, err)
+		}
+		if hex.EncodeToString(tree.MerkleRoot()) != rfc6962Roots[n-1] {
+			t.Errorf("[size:%d] error: expected root %s got %x", n, rfc6962Roots[n-1], tree.MerkleRoot())
+		}
+		if hex.EncodeToString(tree.Leafs[0].Hash) != rfc6962Roots[0] {
+			t.Errorf("[size:%d] error: expected leaf hash %s got %x", n, rfc6962Roots[0], tree.Leafs[0].Hash)
+		}
+	}
+}
+
+func TestNewTreeWithOptions_DomainSeparation(t *testing.T) {
+	for i := 0; i < len(table); i++ {
+		tree, err := NewTreeWithOptions(table[i].contents, WithHashStrategy(table[i].hashStrategy), WithDomainSeparation())
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseId, err)
+		}
+		if bytes.Equal(tree.MerkleRoot(), table[i].expectedHash) {
+			t.Errorf("[case:%d] error: expected domain separated root to differ from plain root", table[i].testCaseId)
+		}
+		v, err := tree.VerifyTree()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !v {
+			t.Errorf("[case:%d] error: expected tree to be valid", table[i].testCaseId)
+		}
+		for j, c := range table[i].contents {
+			v, err := tree.VerifyContent(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !v {
+				t.Errorf("[case:%d] error: expected valid content %d", table[i].testCaseId, j)
+			}
+			merklePath, index, err := tree.GetMerklePath(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err = VerifyMerklePath(tree.MerkleRoot(), c, merklePath, index, table[i].hashStrategy, WithDomainSeparation())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !v {
+				t.Errorf("[case:%d] error: expected merkle path of content %d to verify", table[i].testCaseId, j)
+			}
+			v, err = VerifyMerklePath(tree.MerkleRoot(), c, merklePath, index, table[i].hashStrategy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v {
+				t.Errorf("[case:%d] error: expected merkle path of content %d to fail without domain separation", table[i].testCaseId, j)
+			}
+			p, err := tree.GenerateProof(j)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err = p.VerifyContent(tree.MerkleRoot(), tree.Len(), c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !v {
+				t.Errorf("[case:%d] error: expected proof of content %d to verify", table[i].testCaseId, j)
+			}
+		}
+	}
+}
+
+func TestNewTreeWithOptions_DomainSeparationRejectsInteriorAsLeaf(t *testing.T) {
+	plain, err := NewTree(table[0].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Without domain separation the hash of an interior node is a valid leaf hash one level up.
+	interior := plain.Root.Left.Hash
+	ok, err := VerifyMerklePathHash(plain.MerkleRoot(), interior, [][]byte{plain.Root.Right.Hash}, []int64{1}, sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("error: expected interior node to verify as leaf without domain separation")
+	}
+
+	tree, err := NewTreeWithOptions(table[0].contents, WithDomainSeparation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := TestRawContent{x: append(append([]byte{}, tree.Leafs[0].Hash...), tree.Leafs[1].Hash...)}
+	ok, err = VerifyMerklePath(tree.MerkleRoot(), forged, [][]byte{tree.Root.Right.Hash}, []int64{1}, sha256.New, WithDomainSeparation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("error: expected interior node to be rejected as leaf with domain separation")
+	}
+}
+
+func TestNewTreeWithOptions_OddLeafPolicyRFC6962(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafRFC6962, OddLeafPromote} {
+		for n := 1; n <= len(rfc6962Leaves); n++ {
+			tree, err := NewTreeWithOptions(rfc6962Leaves[:n], WithDomainSeparation(), WithOddLeafPolicy(policy))
+			if err != nil {
+				t.Fatalf("[%v size:%d] error: unexpected error: %v", policy, n, err)
+			}
+			if hex.EncodeToString(tree.MerkleRoot()) != rfc6962Roots[n-1] {
+				t.Errorf("[%v size:%d] error: expected root %s got %x", policy, n, rfc6962Roots[n-1], tree.MerkleRoot())
+			}
+			if len(tree.Leafs) != n {
+				t.Errorf("[%v size:%d] error: expected %d leafs got %d", policy, n, n, len(tree.Leafs))
+			}
+			v, err := tree.VerifyTree()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !v {
+				t.Errorf("[%v size:%d] error: expected tree to be valid", policy, n)
+			}
+			for j, c := range rfc6962Leaves[:n] {
+				v, err := tree.VerifyContent(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !v {
+					t.Errorf("[%v size:%d] error: expected valid content %d", policy, n, j)
+				}
+			}
+		}
+	}
+}
+
+func TestNewTreeWithOptions_OddLeafPolicyDuplicateAmbiguity(t *testing.T) {
+	contents := table[1].contents
+	padded := append(append([]Content{}, contents...), contents[len(contents)-1])
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		a, err := NewTreeWithOptions(contents, WithOddLeafPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := NewTreeWithOptions(padded, WithOddLeafPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		same := bytes.Equal(a.MerkleRoot(), b.MerkleRoot())
+		if policy == OddLeafDuplicate && !same {
+			t.Errorf("[%v] error: expected duplicate policy to keep its historical root", policy)
+		}
+		if policy != OddLeafDuplicate && same {
+			t.Errorf("[%v] error: expected different roots for different content lists", policy)
+		}
+	}
+	if _, err := NewTreeWithOptions(contents, WithOddLeafPolicy(OddLeafPolicy(42))); err == nil {
+		t.Error("error: expected error for unknown odd leaf policy")
+	}
+}
+
+func TestMerkleTree_GetMerklePathByIndex(t *testing.T) {
+	contents := []Content{
+		TestSHA256Content{x: "Hello"},
+		TestSHA256Content{x: "Hello"},
+		TestSHA256Content{x: "Hi"},
+		TestSHA256Content{x: "Hello"},
+		TestSHA256Content{x: "Hello"},
+	}
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		tree, err := NewTreeWithOptions(contents, WithOddLeafPolicy(policy))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, c := range contents {
+			merklePath, index, err := tree.GetMerklePathByIndex(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			steps := proofPath(i, len(contents), policy)
+			if len(index) != len(steps) {
+				t.Fatalf("[%v leaf:%d] error: expected %d indexes got %d", policy, i, len(steps), len(index))
+			}
+			for k := range steps {
+				if index[k] != steps[k].index {
+					t.Errorf("[%v leaf:%d] error: expected index %d at level %d got %d", policy, i, steps[k].index, k, index[k])
+				}
+			}
+			ok, err := VerifyMerklePath(tree.MerkleRoot(), c, merklePath, index, sha256.New, WithOddLeafPolicy(policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Errorf("[%v leaf:%d] error: expected merkle path to verify", policy, i)
+			}
+		}
+		if _, _, err := tree.GetMerklePathByIndex(len(contents)); err == nil {
+			t.Errorf("[%v] error: expected error for out of range index", policy)
+		}
+		if _, _, err := tree.GetMerklePathByIndex(-1); err == nil {
+			t.Errorf("[%v] error: expected error for negative index", policy)
+		}
+	}
+}
+
+func TestMerkleTree_IndexesOf(t *testing.T) {
+	contents := []Content{
+		TestSHA256Content{x: "Hello"},
+		TestSHA256Content{x: "Hi"},
+		TestSHA256Content{x: "Hello"},
+	}
+	tree, err := NewTree(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexes, err := tree.IndexesOf(TestSHA256Content{x: "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	//The duplicate padding leaf also holds "Hello" but is not reported.
+	if len(indexes) != 2 || indexes[0] != 0 || indexes[1] != 2 {
+		t.Errorf("error: expected indexes [0 2] got %v", indexes)
+	}
+	indexes, err = tree.IndexesOf(TestSHA256Content{x: "NotInTestTable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(indexes) != 0 {
+		t.Errorf("error: expected no indexes got %v", indexes)
+	}
+}
+
+//countingContent wraps a TestSHA256Content and counts the calls to Equals.
+type countingContent struct {
+	TestSHA256Content
+	calls *int
+}
+
+//Equals tests for equality of two Contents
+func (t countingContent) Equals(other Content) (bool, error) {
+	*t.calls++
+	return t.x == other.(countingContent).x, nil
+}
+
+func TestMerkleTree_LeafIndex(t *testing.T) {
+	for i := 0; i < len(table); i++ {
+		tree, err := NewTreeWithHashStrategy(table[i].contents, table[i].hashStrategy)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseId, err)
+		}
+		for j, c := range table[i].contents {
+			hash, err := c.CalculateHash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !tree.HasLeafHash(hash) {
+				t.Errorf("[case:%d] error: expected leaf hash %d to be present", table[i].testCaseId, j)
+			}
+			index, ok := tree.LeafIndex(hash)
+			if !ok || index != j {
+				t.Errorf("[case:%d] error: expected leaf index %d got %d", table[i].testCaseId, j, index)
+			}
+		}
+		hash, err := table[i].notInContents.CalculateHash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tree.HasLeafHash(hash) {
+			t.Errorf("[case:%d] error: expected leaf hash to be absent", table[i].testCaseId)
+		}
+		if _, ok := tree.LeafIndex(hash); ok {
+			t.Errorf("[case:%d] error: expected no leaf index", table[i].testCaseId)
+		}
+	}
+}
+
+func TestMerkleTree_LeafIndexAfterRebuild(t *testing.T) {
+	tree, err := NewTree(table[0].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.RebuildTreeWith(table[3].contents); err != nil {
+		t.Fatal(err)
+	}
+	old, _ := table[0].contents[0].CalculateHash()
+	if tree.HasLeafHash(old) {
+		t.Error("error: expected replaced content to be removed from the index")
+	}
+	for j, c := range table[3].contents {
+		hash, _ := c.CalculateHash()
+		if index, ok := tree.LeafIndex(hash); !ok || index != j {
+			t.Errorf("error: expected leaf index %d got %d", j, index)
+		}
+	}
+	if err := tree.RebuildTree(); err != nil {
+		t.Fatal(err)
+	}
+	for j, c := range table[3].contents {
+		hash, _ := c.CalculateHash()
+		if index, ok := tree.LeafIndex(hash); !ok || index != j {
+			t.Errorf("error: expected leaf index %d after rebuild got %d", j, index)
+		}
+	}
+}
+
+func TestMerkleTree_LeafIndexDomainSeparation(t *testing.T) {
+	tree, err := NewTreeWithOptions(table[0].contents, WithDomainSeparation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	contentHash, _ := table[0].contents[1].CalculateHash()
+	if tree.HasLeafHash(contentHash) {
+		t.Error("error: expected content hash not to be a leaf node hash")
+	}
+	if index, ok := tree.LeafIndex(tree.Leafs[1].Hash); !ok || index != 1 {
+		t.Errorf("error: expected leaf index 1 got %d", index)
+	}
+}
+
+func TestMerkleTree_ContentLookupUsesIndex(t *testing.T) {
+	calls := 0
+	var contents []Content
+	for i := 0; i < 64; i++ {
+		contents = append(contents, countingContent{TestSHA256Content{x: string(rune('A' + i))}, &calls})
+	}
+	tree, err := NewTree(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := contents[40]
+	if _, _, err := tree.GetMerklePath(target); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := tree.VerifyContent(target); err != nil || !ok {
+		t.Fatalf("error: expected valid content, got %v %v", ok, err)
+	}
+	if _, err := tree.IndexesOf(countingContent{TestSHA256Content{x: "NotInTestTable"}, &calls}); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("error: expected 2 calls to Equals got %d", calls)
+	}
+}
+
+func numberedContents(n int) []Content {
+	var cs []Content
+	for i := 0; i < n; i++ {
+		cs = append(cs, TestSHA256Content{x: fmt.Sprintf("content-%d", i)})
+	}
+	return cs
+}
+
+func TestMerkleTree_Append(t *testing.T) {
+	policies := []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962}
+	for _, policy := range policies {
+		for _, domainSeparation := range []bool{false, true} {
+			opts := []Option{WithOddLeafPolicy(policy)}
+			if domainSeparation {
+				opts = append(opts, WithDomainSeparation())
+			}
+			for n := 1; n <= 9; n++ {
+				for k := 1; k <= 9; k++ {
+					cs := numberedContents(n + k)
+					tree, err := NewTreeWithOptions(cs[:n], opts...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := tree.Append(cs[n:]...); err != nil {
+						t.Fatal(err)
+					}
+					expected, err := NewTreeWithOptions(cs, opts...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+						t.Errorf("[%v %t n:%d k:%d] error: expected root %x got %x", policy, domainSeparation, n, k, expected.MerkleRoot(), tree.MerkleRoot())
+					}
+					if len(tree.Leafs) != len(expected.Leafs) {
+						t.Errorf("[%v %t n:%d k:%d] error: expected %d leafs got %d", policy, domainSeparation, n, k, len(expected.Leafs), len(tree.Leafs))
+					}
+					v, err := tree.VerifyTree()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !v {
+						t.Errorf("[%v %t n:%d k:%d] error: expected tree to be valid", policy, domainSeparation, n, k)
+					}
+					for j, c := range cs {
+						p, err := tree.GenerateProof(j)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if ok, err := p.VerifyContent(tree.MerkleRoot(), tree.Len(), c); err != nil || !ok {
+							t.Errorf("[%v %t n:%d k:%d] error: expected proof for leaf %d to verify: %v", policy, domainSeparation, n, k, j, err)
+						}
+						hash, err := tree.hashContent(c)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if index, ok := tree.LeafIndex(hash); !ok || index != j {
+							t.Errorf("[%v %t n:%d k:%d] error: expected leaf index %d got %d", policy, domainSeparation, n, k, j, index)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleTree_AppendHashesRightEdgeOnly(t *testing.T) {
+	calls := 0
+	hashStrategy := func() hash.Hash {
+		calls++
+		return sha256.New()
+	}
+	tree, err := NewTreeWithHashStrategy(numberedContents(1000), hashStrategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls = 0
+	if err := tree.Append(numberedContents(1001)[1000]); err != nil {
+		t.Fatal(err)
+	}
+	//A tree of 1001 leaves has 10 levels above the leaves; appending one leaf rehashes one
+	//node per level.
+	if calls > 10 {
+		t.Errorf("error: expected at most 10 hashes for a single append got %d", calls)
+	}
+	if err := tree.Append(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMerkleTree_AppendCopiesRightEdgeOnly(t *testing.T) {
+	cs := numberedContents(1<<16 + 256)
+	tree, err := NewTree(cs[:1<<16])
+	if err != nil {
+		t.Fatal(err)
+	}
+	//The levels grow in place, so an append allocates the new nodes and, amortized, the growth of
+	//the levels instead of a copy of every level.
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for _, c := range cs[1<<16:] {
+		if err := tree.Append(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runtime.ReadMemStats(&after)
+	if perAppend := (after.TotalAlloc - before.TotalAlloc) / 256; perAppend > 32<<10 {
+		t.Errorf("error: expected an append to allocate at most 32KiB got %d bytes", perAppend)
+	}
+	expected, err := NewTree(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected root %x got %x", expected.MerkleRoot(), tree.MerkleRoot())
+	}
+}
+
+func BenchmarkMerkleTree_Append(b *testing.B) {
+	for _, size := range []int{1 << 12, 1 << 16, 1 << 20} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			cs := numberedContents(size + b.N)
+			tree, err := NewTree(cs[:size])
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := tree.Append(cs[size+i]); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMerkleTree_RebuildTreeOddLeafCount(t *testing.T) {
+	tree, err := NewTree(table[1].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.RebuildTree(); err != nil {
+		t.Fatal(err)
+	}
+	//The duplicate padding leaf must not become content of the rebuilt tree.
+	if tree.leafCount() != len(table[1].contents) {
+		t.Errorf("error: expected %d contents got %d", len(table[1].contents), tree.leafCount())
+	}
+}
+
+func TestMerkleTree_UpdateLeaf(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		for _, domainSeparation := range []bool{false, true} {
+			opts := []Option{WithOddLeafPolicy(policy)}
+			if domainSeparation {
+				opts = append(opts, WithDomainSeparation())
+			}
+			for n := 1; n <= 9; n++ {
+				for i := 0; i < n; i++ {
+					cs := numberedContents(n)
+					tree, err := NewTreeWithOptions(cs, opts...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					before := tree.MerkleRoot()
+					updated := TestSHA256Content{x: "updated"}
+					oldRoot, newRoot, err := tree.UpdateLeaf(i, updated)
+					if err != nil {
+						t.Fatal(err)
+					}
+					cs[i] = updated
+					expected, err := NewTreeWithOptions(cs, opts...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(oldRoot, before) {
+						t.Errorf("[%v %t n:%d i:%d] error: expected old root %x got %x", policy, domainSeparation, n, i, before, oldRoot)
+					}
+					if !bytes.Equal(newRoot, expected.MerkleRoot()) || !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+						t.Errorf("[%v %t n:%d i:%d] error: expected new root %x got %x", policy, domainSeparation, n, i, expected.MerkleRoot(), newRoot)
+					}
+					v, err := tree.VerifyTree()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !v {
+						t.Errorf("[%v %t n:%d i:%d] error: expected tree to be valid", policy, domainSeparation, n, i)
+					}
+					if ok, err := tree.VerifyContent(updated); err != nil || !ok {
+						t.Errorf("[%v %t n:%d i:%d] error: expected updated content to verify: %v", policy, domainSeparation, n, i, err)
+					}
+					indexes, err := tree.IndexesOf(updated)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(indexes) != 1 || indexes[0] != i {
+						t.Errorf("[%v %t n:%d i:%d] error: expected updated content at %d got %v", policy, domainSeparation, n, i, i, indexes)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleTree_UpdateLeafErrors(t *testing.T) {
+	tree, err := NewTree(table[1].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//The duplicate padding leaf cannot be updated on its own.
+	if _, _, err := tree.UpdateLeaf(len(table[1].contents), TestSHA256Content{x: "updated"}); err == nil {
+		t.Error("error: expected error for out of range index")
+	}
+	old := table[1].contents[0]
+	if _, _, err := tree.UpdateLeaf(0, TestSHA256Content{x: "updated"}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := tree.VerifyContent(old); err != nil || ok {
+		t.Errorf("error: expected replaced content to be absent: %v", err)
+	}
+}
+
+func TestMerkleTree_RemoveLeaf(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		for _, domainSeparation := range []bool{false, true} {
+			opts := []Option{WithOddLeafPolicy(policy)}
+			if domainSeparation {
+				opts = append(opts, WithDomainSeparation())
+			}
+			for n := 2; n <= 10; n++ {
+				for i := 0; i < n; i++ {
+					cs := numberedContents(n)
+					tree, err := NewTreeWithOptions(cs, opts...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if err := tree.RemoveLeaf(i); err != nil {
+						t.Fatal(err)
+					}
+					remaining := append(append([]Content{}, cs[:i]...), cs[i+1:]...)
+					expected, err := NewTreeWithOptions(remaining, opts...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+						t.Errorf("[%v %t n:%d i:%d] error: expected root %x got %x", policy, domainSeparation, n, i, expected.MerkleRoot(), tree.MerkleRoot())
+					}
+					if len(tree.Leafs) != len(expected.Leafs) {
+						t.Errorf("[%v %t n:%d i:%d] error: expected %d leafs got %d", policy, domainSeparation, n, i, len(expected.Leafs), len(tree.Leafs))
+					}
+					if tree.Root.Parent != nil {
+						t.Errorf("[%v %t n:%d i:%d] error: expected root without parent", policy, domainSeparation, n, i)
+					}
+					v, err := tree.VerifyTree()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !v {
+						t.Errorf("[%v %t n:%d i:%d] error: expected tree to be valid", policy, domainSeparation, n, i)
+					}
+					if ok, err := tree.VerifyContent(cs[i]); err != nil || ok {
+						t.Errorf("[%v %t n:%d i:%d] error: expected removed content to be absent: %v", policy, domainSeparation, n, i, err)
+					}
+					for j, c := range remaining {
+						p, err := tree.GenerateProof(j)
+						if err != nil {
+							t.Fatal(err)
+						}
+						if ok, err := p.VerifyContent(tree.MerkleRoot(), tree.Len(), c); err != nil || !ok {
+							t.Errorf("[%v %t n:%d i:%d] error: expected proof for leaf %d to verify: %v", policy, domainSeparation, n, i, j, err)
+						}
+						if indexes, err := tree.IndexesOf(c); err != nil || len(indexes) != 1 || indexes[0] != j {
+							t.Errorf("[%v %t n:%d i:%d] error: expected content at %d got %v", policy, domainSeparation, n, i, j, indexes)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleTree_RemoveContent(t *testing.T) {
+	repeated := TestSHA256Content{x: "Hello"}
+	cs := []Content{repeated, TestSHA256Content{x: "Hi"}, repeated, TestSHA256Content{x: "Hey"}, repeated}
+	tree, err := NewTree(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := tree.RemoveContent(TestSHA256Content{x: "NotInTestTable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 0 {
+		t.Errorf("error: expected nothing removed got %d", removed)
+	}
+	removed, err = tree.RemoveContent(repeated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 3 {
+		t.Errorf("error: expected 3 leaves removed got %d", removed)
+	}
+	expected, err := NewTree([]Content{cs[1], cs[3]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected root %x got %x", expected.MerkleRoot(), tree.MerkleRoot())
+	}
+	if err := tree.RemoveLeaf(2); err == nil {
+		t.Error("error: expected error for out of range index")
+	}
+	if err := tree.RemoveLeaf(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.RemoveLeaf(0); err == nil {
+		t.Error("error: expected error when removing the only leaf")
+	}
+	if _, err := tree.RemoveContent(cs[3]); err == nil {
+		t.Error("error: expected error when removing the only content")
+	}
+}
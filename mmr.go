@@ -0,0 +1,293 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash"
+	"math/bits"
+)
+
+//MerkleMountainRange is an append-only accumulator made of perfect binary trees, the mountains,
+//of strictly decreasing height. Appending a leaf adds a mountain of height 0 and merges the two
+//rightmost mountains while they have the same height, so it costs O(log n) hashes and never
+//changes existing nodes. The path from a leaf to the peak of its mountain therefore stays valid
+//and only grows as mountains merge, which UpdateProof uses to refresh old proofs.
+//
+//The root bags the peaks from right to left: root = hash(p0, hash(p1, ... hash(pk-1, pk))), which
+//is the root of a MerkleTree built from the same content with OddLeafRFC6962.
+type MerkleMountainRange struct {
+	nodes     [][]byte
+	heights   []int
+	peaks     []int
+	leafCount int
+	root      []byte
+	config
+}
+
+//MountainRangeProof is an inclusion proof for a leaf of a MerkleMountainRange of Size leaves.
+//Siblings are the hashes from the leaf up to the peak of its mountain and Peaks are the peaks of
+//all mountains from left to right.
+type MountainRangeProof struct {
+	LeafIndex int
+	Size      int
+	Siblings  [][]byte
+	Peaks     [][]byte
+}
+
+//NewMerkleMountainRange creates a new empty Merkle Mountain Range using the provided hash strategy.
+//Of the options only WithDomainSeparation changes how the range is hashed.
+func NewMerkleMountainRange(hashStrategy func() hash.Hash, opts ...Option) (*MerkleMountainRange, error) {
+	c := newConfig(hashStrategy, opts)
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return &MerkleMountainRange{config: c}, nil
+}
+
+//Len returns the number of leaves of the range.
+func (m *MerkleMountainRange) Len() int {
+	return m.leafCount
+}
+
+//MerkleRoot returns the bagged peaks of the range, or nil if it is empty.
+func (m *MerkleMountainRange) MerkleRoot() []byte {
+	return m.root
+}
+
+//Peaks returns the hashes of the peaks of the mountains from left to right.
+func (m *MerkleMountainRange) Peaks() [][]byte {
+	peaks := make([][]byte, len(m.peaks))
+	for i, pos := range m.peaks {
+		peaks[i] = m.nodes[pos]
+	}
+	return peaks
+}
+
+//Append adds the contents cs as new leaves. The range is left unchanged if an error is returned.
+func (m *MerkleMountainRange) Append(cs ...Content) error {
+	leafs := make([][]byte, len(cs))
+	for i, c := range cs {
+		var err error
+		if leafs[i], err = m.hashContent(c); err != nil {
+			return &LeafHashError{Index: m.leafCount + i, Err: err}
+		}
+	}
+	nodes, heights, peaks, leafCount := len(m.nodes), len(m.heights), append([]int{}, m.peaks...), m.leafCount
+	for _, leaf := range leafs {
+		if err := m.push(leaf); err != nil {
+			m.nodes, m.heights, m.peaks, m.leafCount = m.nodes[:nodes], m.heights[:heights], peaks, leafCount
+			return err
+		}
+	}
+	root, err := bagPeaks(m.Peaks(), &m.config)
+	if err != nil {
+		m.nodes, m.heights, m.peaks, m.leafCount = m.nodes[:nodes], m.heights[:heights], peaks, leafCount
+		return err
+	}
+	m.root = root
+	return nil
+}
+
+//push adds a leaf and merges the rightmost mountains while they have the same height.
+func (m *MerkleMountainRange) push(leaf []byte) error {
+	m.peaks = append(m.peaks, len(m.nodes))
+	m.nodes = append(m.nodes, leaf)
+	m.heights = append(m.heights, 0)
+	m.leafCount++
+	for n := len(m.peaks); n >= 2 && m.heights[m.peaks[n-1]] == m.heights[m.peaks[n-2]]; n = len(m.peaks) {
+		left, right := m.peaks[n-2], m.peaks[n-1]
+		hash, err := m.hashChildren(m.nodes[left], m.nodes[right])
+		if err != nil {
+			return err
+		}
+		m.peaks = append(m.peaks[:n-2], len(m.nodes))
+		m.nodes = append(m.nodes, hash)
+		m.heights = append(m.heights, m.heights[right]+1)
+	}
+	return nil
+}
+
+//bagPeaks returns the root of peaks, hashing them from right to left.
+func bagPeaks(peaks [][]byte, c *config) ([]byte, error) {
+	if len(peaks) == 0 {
+		return nil, nil
+	}
+	root := peaks[len(peaks)-1]
+	for i := len(peaks) - 2; i >= 0; i-- {
+		var err error
+		if root, err = c.hashChildren(peaks[i], root); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+//GenerateProof returns the inclusion proof for the leaf at index against the current root. Returns
+//an error if index is out of range.
+func (m *MerkleMountainRange) GenerateProof(index int) (*MountainRangeProof, error) {
+	if index < 0 || index >= m.leafCount {
+		return nil, fmt.Errorf("error: leaf index %d out of range [0, %d)", index, m.leafCount)
+	}
+	//Every leaf before index added one node plus one for each merge it caused.
+	pos := 2*index - popCount(index)
+	p := &MountainRangeProof{LeafIndex: index, Size: m.leafCount, Peaks: m.Peaks()}
+	for {
+		h := m.heights[pos]
+		span := 1<<uint(h+1) - 1
+		if pos+1 < len(m.nodes) && m.heights[pos+1] > h {
+			//A right child is directly followed by its parent.
+			p.Siblings = append(p.Siblings, m.nodes[pos-span])
+			pos++
+		} else if pos+span < len(m.nodes) {
+			p.Siblings = append(p.Siblings, m.nodes[pos+span])
+			pos += span + 1
+		} else {
+			return p, nil
+		}
+	}
+}
+
+//UpdateProof returns the proof of the leaf of p, an earlier proof of this range, against the
+//current root. Appends never change existing nodes, so the siblings of p are a prefix of the new
+//siblings: only the siblings above the old peak of the leaf and the peaks are new. Returns an error
+//if p is not a proof of this range.
+func (m *MerkleMountainRange) UpdateProof(p *MountainRangeProof) (*MountainRangeProof, error) {
+	if p.Size <= 0 || p.Size > m.leafCount || p.LeafIndex < 0 || p.LeafIndex >= p.Size {
+		return nil, fmt.Errorf("error: proof of leaf %d of %d leaves does not match a range of %d leaves", p.LeafIndex, p.Size, m.leafCount)
+	}
+	updated, err := m.GenerateProof(p.LeafIndex)
+	if err != nil {
+		return nil, err
+	}
+	peaks := m.peaksAt(p.Size)
+	if len(p.Siblings) > len(updated.Siblings) || len(p.Peaks) != len(peaks) {
+		return nil, errors.New("error: proof does not match the range")
+	}
+	for i, sibling := range p.Siblings {
+		if !bytes.Equal(sibling, updated.Siblings[i]) {
+			return nil, errors.New("error: proof does not match the range")
+		}
+	}
+	for i, peak := range p.Peaks {
+		if !bytes.Equal(peak, peaks[i]) {
+			return nil, errors.New("error: proof does not match the range")
+		}
+	}
+	return updated, nil
+}
+
+//peaksAt returns the peaks of the range when it held size leaves. The peak of each mountain is the
+//last node added once the leaves up to its end were appended, and a range of n leaves holds
+//2n - popCount(n) nodes.
+func (m *MerkleMountainRange) peaksAt(size int) [][]byte {
+	var peaks [][]byte
+	offset := 0
+	for h := bits.Len(uint(size)) - 1; h >= 0; h-- {
+		if size&(1<<uint(h)) != 0 {
+			offset += 1 << uint(h)
+			peaks = append(peaks, m.nodes[2*offset-popCount(offset)-1])
+		}
+	}
+	return peaks
+}
+
+//popCount returns the number of bits set in n.
+func popCount(n int) int {
+	count := 0
+	for ; n > 0; n &= n - 1 {
+		count++
+	}
+	return count
+}
+
+//Verify checks that the leaf with hash leafHash sits at position LeafIndex of a Merkle Mountain
+//Range of size leaves with root root, built with hashStrategy and opts. root and size must come
+//from a trusted source; a proof for another size than size is rejected, since the size decides the
+//shape of the mountains. Returns an error if the proof is malformed.
+func (p *MountainRangeProof) Verify(root []byte, size int, leafHash []byte, hashStrategy func() hash.Hash, opts ...Option) (bool, error) {
+	c := newConfig(hashStrategy, opts)
+	if err := c.validate(); err != nil {
+		return false, err
+	}
+	if size <= 0 {
+		return false, fmt.Errorf("error: invalid range size %d", size)
+	}
+	if p.Size != size {
+		return false, nil
+	}
+	if p.Size <= 0 || p.LeafIndex < 0 || p.LeafIndex >= p.Size {
+		return false, fmt.Errorf("error: leaf index %d out of range [0, %d)", p.LeafIndex, p.Size)
+	}
+	if len(p.Peaks) != popCount(p.Size) {
+		return false, fmt.Errorf("%w: %d peaks, expected %d for %d leaves", ErrPathLengthMismatch, len(p.Peaks), popCount(p.Size), p.Size)
+	}
+	hashSize := hashStrategy().Size()
+	for _, hashes := range [][][]byte{{root, leafHash}, p.Siblings, p.Peaks} {
+		for _, h := range hashes {
+			if len(h) != hashSize {
+				return false, fmt.Errorf("%w: got %d bytes, expected %d", ErrInvalidHashSize, len(h), hashSize)
+			}
+		}
+	}
+
+	//Find the mountain holding the leaf; mountains follow the bits of Size from the highest.
+	top := 0
+	for 1<<uint(top+1) <= p.Size {
+		top++
+	}
+	peak, offset, height := 0, 0, 0
+	for h := top; h >= 0; h-- {
+		if p.Size&(1<<uint(h)) == 0 {
+			continue
+		}
+		if p.LeafIndex < offset+1<<uint(h) {
+			height = h
+			break
+		}
+		offset += 1 << uint(h)
+		peak++
+	}
+	if len(p.Siblings) != height {
+		return false, fmt.Errorf("%w: %d siblings, expected %d", ErrPathLengthMismatch, len(p.Siblings), height)
+	}
+	current := leafHash
+	index := p.LeafIndex - offset
+	for _, sibling := range p.Siblings {
+		var err error
+		if index&1 == 1 {
+			current, err = c.hashChildren(sibling, current)
+		} else {
+			current, err = c.hashChildren(current, sibling)
+		}
+		if err != nil {
+			return false, err
+		}
+		index >>= 1
+	}
+	if !bytes.Equal(current, p.Peaks[peak]) {
+		return false, nil
+	}
+	bagged, err := bagPeaks(p.Peaks, &c)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(bagged, root), nil
+}
+
+//VerifyContent checks that content sits at position LeafIndex of a Merkle Mountain Range of size
+//leaves with root root, built with hashStrategy and opts.
+func (p *MountainRangeProof) VerifyContent(root []byte, size int, content Content, hashStrategy func() hash.Hash, opts ...Option) (bool, error) {
+	c := newConfig(hashStrategy, opts)
+	if err := c.validate(); err != nil {
+		return false, err
+	}
+	leafHash, err := c.hashContent(content)
+	if err != nil {
+		return false, err
+	}
+	return p.Verify(root, size, leafHash, hashStrategy, opts...)
+}
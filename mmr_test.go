@@ -0,0 +1,203 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"testing"
+)
+
+func TestMerkleMountainRange(t *testing.T) {
+	for _, domainSeparation := range []bool{false, true} {
+		var opts []Option
+		if domainSeparation {
+			opts = append(opts, WithDomainSeparation())
+		}
+		mmr, err := NewMerkleMountainRange(sha256.New, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mmr.MerkleRoot() != nil {
+			t.Errorf("[%t] error: expected empty range to have no root", domainSeparation)
+		}
+		cs := numberedContents(40)
+		var proofs []*MountainRangeProof
+		var roots [][]byte
+		for n := 1; n <= len(cs); n++ {
+			if err := mmr.Append(cs[n-1]); err != nil {
+				t.Fatal(err)
+			}
+			if mmr.Len() != n || len(mmr.Peaks()) != popCount(n) {
+				t.Fatalf("[%t size:%d] error: expected %d peaks got %d", domainSeparation, n, popCount(n), len(mmr.Peaks()))
+			}
+			tree, err := NewTreeWithOptions(cs[:n], append(opts, WithOddLeafPolicy(OddLeafRFC6962))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(mmr.MerkleRoot(), tree.MerkleRoot()) {
+				t.Errorf("[%t size:%d] error: expected root %x got %x", domainSeparation, n, tree.MerkleRoot(), mmr.MerkleRoot())
+			}
+			for i := 0; i < n; i++ {
+				p, err := mmr.GenerateProof(i)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ok, err := p.VerifyContent(mmr.MerkleRoot(), n, cs[i], sha256.New, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					t.Errorf("[%t size:%d] error: expected proof of leaf %d to verify", domainSeparation, n, i)
+				}
+				if ok, err := p.VerifyContent(mmr.MerkleRoot(), n, cs[(i+1)%len(cs)], sha256.New, opts...); err != nil || ok {
+					t.Errorf("[%t size:%d] error: expected proof of other content to fail: %v", domainSeparation, n, err)
+				}
+			}
+			p, err := mmr.GenerateProof(n - 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			proofs = append(proofs, p)
+			roots = append(roots, mmr.MerkleRoot())
+		}
+		//Proofs keep verifying against the root they were generated for after appends, and update
+		//to the current root by extending their siblings.
+		for i, p := range proofs {
+			if ok, err := p.VerifyContent(roots[i], i+1, cs[i], sha256.New, opts...); err != nil || !ok {
+				t.Errorf("[%t] error: expected old proof of leaf %d to verify: %v", domainSeparation, i, err)
+			}
+			if ok, err := p.VerifyContent(mmr.MerkleRoot(), mmr.Len(), cs[i], sha256.New, opts...); i+1 < mmr.Len() && (err != nil || ok) {
+				t.Errorf("[%t] error: expected old proof of leaf %d not to verify against the current root: %v", domainSeparation, i, err)
+			}
+			updated, err := mmr.UpdateProof(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := updated.VerifyContent(mmr.MerkleRoot(), mmr.Len(), cs[i], sha256.New, opts...); err != nil || !ok {
+				t.Errorf("[%t] error: expected updated proof of leaf %d to verify: %v", domainSeparation, i, err)
+			}
+			for j, sibling := range p.Siblings {
+				if !bytes.Equal(sibling, updated.Siblings[j]) {
+					t.Errorf("[%t] error: expected sibling %d of leaf %d to survive the update", domainSeparation, j, i)
+				}
+			}
+			forged := *p
+			forged.Peaks = append([][]byte{}, p.Peaks...)
+			forged.Peaks[0] = make([]byte, sha256.Size)
+			if _, err := mmr.UpdateProof(&forged); err == nil {
+				t.Errorf("[%t] error: expected error updating a proof with other peaks", domainSeparation)
+			}
+		}
+	}
+}
+
+func TestMerkleMountainRange_ProofSize(t *testing.T) {
+	mmr, err := NewMerkleMountainRange(md5.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mmr.Append(numberedContents(1000)...); err != nil {
+		t.Fatal(err)
+	}
+	//1000 = 512 + 256 + 128 + 64 + 32 + 8.
+	for _, tc := range []struct{ index, siblings int }{{0, 9}, {511, 9}, {512, 8}, {990, 5}, {999, 3}} {
+		p, err := mmr.GenerateProof(tc.index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(p.Siblings) != tc.siblings || len(p.Peaks) != 6 {
+			t.Errorf("[leaf:%d] error: expected %d siblings and 6 peaks got %d and %d", tc.index, tc.siblings, len(p.Siblings), len(p.Peaks))
+		}
+	}
+}
+
+func TestMerkleMountainRange_Errors(t *testing.T) {
+	if _, err := NewMerkleMountainRange(nil); !errors.Is(err, ErrNilHashStrategy) {
+		t.Errorf("error: expected ErrNilHashStrategy got %v", err)
+	}
+	mmr, err := NewMerkleMountainRange(sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := mmr.Append(numberedContents(5)...); err != nil {
+		t.Fatal(err)
+	}
+	root := mmr.MerkleRoot()
+	var leafErr *LeafHashError
+	if err := mmr.Append(TestSHA256Content{x: "ok"}, nil); !errors.As(err, &leafErr) || leafErr.Index != 6 {
+		t.Errorf("error: expected LeafHashError for leaf 6 got %v", err)
+	}
+	if mmr.Len() != 5 || !bytes.Equal(mmr.MerkleRoot(), root) {
+		t.Error("error: expected range to be unchanged after a failed append")
+	}
+	for _, i := range []int{-1, 5} {
+		if _, err := mmr.GenerateProof(i); err == nil {
+			t.Errorf("[leaf:%d] error: expected error for index out of range", i)
+		}
+	}
+	p, err := mmr.GenerateProof(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafHash, err := mmr.hashContent(numberedContents(5)[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Verify(root, 5, leafHash, nil); !errors.Is(err, ErrNilHashStrategy) {
+		t.Errorf("error: expected ErrNilHashStrategy got %v", err)
+	}
+	if _, err := p.Verify(root[:4], 5, leafHash, sha256.New); !errors.Is(err, ErrInvalidHashSize) {
+		t.Errorf("error: expected ErrInvalidHashSize got %v", err)
+	}
+	short := *p
+	short.Siblings = short.Siblings[1:]
+	if _, err := short.Verify(root, 5, leafHash, sha256.New); !errors.Is(err, ErrPathLengthMismatch) {
+		t.Errorf("error: expected ErrPathLengthMismatch got %v", err)
+	}
+	moved := *p
+	moved.LeafIndex = 3
+	if ok, err := moved.Verify(root, 5, leafHash, sha256.New); err != nil || ok {
+		t.Errorf("error: expected proof for another position to fail: %v", err)
+	}
+	if _, err := p.Verify(root, 0, leafHash, sha256.New); err == nil {
+		t.Error("error: expected error for an invalid range size")
+	}
+	if _, err := mmr.UpdateProof(&MountainRangeProof{LeafIndex: 2, Size: 6}); err == nil {
+		t.Error("error: expected error updating a proof of a larger range")
+	}
+}
+
+func TestMountainRangeProof_ForgedSize(t *testing.T) {
+	//Without domain separation a leaf of 64 bytes hashes like an interior node with the two halves
+	//as children, so a proof for another size can pass the halves off as a leaf and a sibling.
+	data := string(bytes.Repeat([]byte{'x'}, 64))
+	mmr, err := NewMerkleMountainRange(sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := []Content{TestSHA256Content{x: data}, TestSHA256Content{x: "b"}, TestSHA256Content{x: "c"}}
+	if err := mmr.Append(cs...); err != nil {
+		t.Fatal(err)
+	}
+	b, err := cs[1].CalculateHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := &MountainRangeProof{
+		LeafIndex: 0,
+		Size:      5,
+		Siblings:  [][]byte{[]byte(data[32:]), b},
+		Peaks:     mmr.Peaks(),
+	}
+	leafHash := []byte(data[:32])
+	if ok, err := forged.Verify(mmr.MerkleRoot(), 5, leafHash, sha256.New); err != nil || !ok {
+		t.Fatalf("error: expected the forgery to hold for the size it claims: %v", err)
+	}
+	if ok, err := forged.Verify(mmr.MerkleRoot(), mmr.Len(), leafHash, sha256.New); err != nil || ok {
+		t.Errorf("error: expected proof for another size to fail: %v", err)
+	}
+}
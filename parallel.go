@@ -0,0 +1,73 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+//parallelGrain is the smallest number of hashes handed to a goroutine. Levels narrower than two
+//grains, such as the top of every tree, are hashed on the calling goroutine.
+const parallelGrain = 512
+
+//WithParallelism hashes the leaves and each level of the tree on up to n goroutines when the tree
+//is built, rebuilt or appended to. A value of n less than 1 uses runtime.GOMAXPROCS(0). The
+//resulting tree is identical to a sequential build. CalculateHash of the Content and the hash
+//strategy must be safe to call from multiple goroutines.
+func WithParallelism(n int) Option {
+	return func(c *config) {
+		if n < 1 {
+			n = runtime.GOMAXPROCS(0)
+		}
+		c.parallelism = n
+	}
+}
+
+//LeafHashError is returned when the Content of a leaf cannot be hashed. If several leaves fail
+//the one with the lowest index is reported, regardless of parallelism.
+type LeafHashError struct {
+	Index int
+	Err   error
+}
+
+//Error returns the error message including the index of the leaf.
+func (e *LeafHashError) Error() string {
+	return fmt.Sprintf("error: cannot hash leaf %d: %v", e.Index, e.Err)
+}
+
+//Unwrap returns the error returned while hashing the leaf.
+func (e *LeafHashError) Unwrap() error {
+	return e.Err
+}
+
+//parallelFor splits [0, n) into consecutive ranges and calls fn for each of them, on separate
+//goroutines if the configured parallelism allows. Returns the error of the lowest failing range,
+//so the first error of a range that processes its items in order matches a sequential run.
+func (c *config) parallelFor(n int, fn func(lo, hi int) error) error {
+	workers := c.parallelism
+	if limit := n / parallelGrain; workers > limit {
+		workers = limit
+	}
+	if workers <= 1 {
+		return fn(0, n)
+	}
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errs[w] = fn(w*n/workers, (w+1)*n/workers)
+		}(w)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
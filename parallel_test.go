@@ -0,0 +1,139 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+//errFailingContent is returned by failingContent.
+var errFailingContent = errors.New("error: failing content")
+
+//failingContent is a Content that cannot be hashed.
+type failingContent struct {
+	TestSHA256Content
+}
+
+//CalculateHash always fails.
+func (t failingContent) CalculateHash() ([]byte, error) {
+	return nil, errFailingContent
+}
+
+func TestMerkleTree_Parallelism(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		for _, domainSeparation := range []bool{false, true} {
+			for _, n := range []int{1, 7, parallelGrain*2 + 1, parallelGrain*5 - 1} {
+				opts := []Option{WithOddLeafPolicy(policy)}
+				if domainSeparation {
+					opts = append(opts, WithDomainSeparation())
+				}
+				cs := numberedContents(n)
+				sequential, err := NewTreeWithOptions(cs, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				parallel, err := NewTreeWithOptions(cs, append(opts, WithParallelism(4))...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(parallel.MerkleRoot(), sequential.MerkleRoot()) {
+					t.Errorf("[%v %t size:%d] error: expected root %x got %x", policy, domainSeparation, n, sequential.MerkleRoot(), parallel.MerkleRoot())
+				}
+				v, err := parallel.VerifyTree()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !v {
+					t.Errorf("[%v %t size:%d] error: expected parallel tree to be valid", policy, domainSeparation, n)
+				}
+				for _, i := range []int{0, n / 2, n - 1} {
+					if ok, err := parallel.VerifyContent(cs[i]); err != nil || !ok {
+						t.Errorf("[%v %t size:%d] error: expected content %d to verify: %v", policy, domainSeparation, n, i, err)
+					}
+				}
+
+				//Appending in parallel matches a sequential build of all of the content.
+				more := numberedContents(n + parallelGrain*3)
+				if err := parallel.Append(more[n:]...); err != nil {
+					t.Fatal(err)
+				}
+				expected, err := NewTreeWithOptions(more, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(parallel.MerkleRoot(), expected.MerkleRoot()) {
+					t.Errorf("[%v %t size:%d] error: expected appended root %x got %x", policy, domainSeparation, n, expected.MerkleRoot(), parallel.MerkleRoot())
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleTree_ParallelismDefault(t *testing.T) {
+	cs := numberedContents(parallelGrain * 3)
+	tree, err := NewTreeWithOptions(cs, WithParallelism(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tree.parallelism < 1 {
+		t.Errorf("error: expected parallelism of at least 1 got %d", tree.parallelism)
+	}
+	expected, err := NewTree(cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected root %x got %x", expected.MerkleRoot(), tree.MerkleRoot())
+	}
+}
+
+func TestMerkleTree_LeafHashError(t *testing.T) {
+	n := parallelGrain * 4
+	for _, parallelism := range []int{1, 4} {
+		cs := numberedContents(n)
+		cs[n-10] = failingContent{}
+		cs[n/2+3] = failingContent{}
+		_, err := NewTreeWithOptions(cs, WithParallelism(parallelism))
+		var leafErr *LeafHashError
+		if !errors.As(err, &leafErr) {
+			t.Fatalf("[parallelism:%d] error: expected LeafHashError got %v", parallelism, err)
+		}
+		if leafErr.Index != n/2+3 {
+			t.Errorf("[parallelism:%d] error: expected failing leaf %d got %d", parallelism, n/2+3, leafErr.Index)
+		}
+		if !errors.Is(err, errFailingContent) {
+			t.Errorf("[parallelism:%d] error: expected error to wrap the content error", parallelism)
+		}
+
+		tree, err := NewTreeWithOptions(numberedContents(5), WithParallelism(parallelism))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = tree.Append(TestSHA256Content{x: "ok"}, nil)
+		if !errors.As(err, &leafErr) || leafErr.Index != 6 {
+			t.Errorf("[parallelism:%d] error: expected LeafHashError for leaf 6 got %v", parallelism, err)
+		}
+	}
+}
+
+func BenchmarkNewTree(b *testing.B) {
+	cs := numberedContents(1 << 16)
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"sequential", nil},
+		{"parallel", []Option{WithParallelism(0)}},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := NewTreeWithOptions(cs, bc.opts...); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,299 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"fmt"
+	"hash"
+	"reflect"
+)
+
+var (
+	//ErrNilHashStrategy is returned when a verifier is given no hash strategy.
+	ErrNilHashStrategy = errors.New("error: hash strategy must not be nil")
+	//ErrPathLengthMismatch is returned when a merkle path and its indexes differ in length.
+	ErrPathLengthMismatch = errors.New("error: merkle path and indexes differ in length")
+	//ErrInvalidHashSize is returned when a root, leaf or sibling hash does not match the
+	//output size of the hash strategy.
+	ErrInvalidHashSize = errors.New("error: hash has wrong size for hash strategy")
+	//ErrInvalidIndex is returned when a path index is neither 0 (left) nor 1 (right).
+	ErrInvalidIndex = errors.New("error: merkle path index must be 0 or 1")
+)
+
+//VerifyMerklePath checks that content is included in the tree with Merkle Root root, using
+//a merkle path and indexes as returned by GetMerklePath. The tree does not need to be held
+//in memory; only the root, the path and the hash strategy used to build the tree are required.
+//Options that change how the tree is hashed, such as WithDomainSeparation, must match the ones
+//the tree was built with.
+func VerifyMerklePath(root []byte, content Content, merklePath [][]byte, indexes []int64, hashStrategy func() hash.Hash, opts ...Option) (bool, error) {
+	if content == nil {
+		return false, errors.New("error: content must not be nil")
+	}
+	if hashStrategy == nil {
+		return false, ErrNilHashStrategy
+	}
+	c := newConfig(hashStrategy, opts)
+	leafHash, err := c.hashContent(content)
+	if err != nil {
+		return false, err
+	}
+	return VerifyMerklePathHash(root, leafHash, merklePath, indexes, hashStrategy, opts...)
+}
+
+//VerifyMerklePathHash checks that the leaf with hash leafHash is included in the tree with
+//Merkle Root root. An index of 1 means the sibling at that level is the right child and an
+//index of 0 means it is the left child, matching the output of GetMerklePath. leafHash is the
+//hash held by the leaf node, which differs from Content.CalculateHash when the tree uses domain
+//separation. Returns an error if the input is malformed and false if the path does not lead to root.
+func VerifyMerklePathHash(root []byte, leafHash []byte, merklePath [][]byte, indexes []int64, hashStrategy func() hash.Hash, opts ...Option) (bool, error) {
+	if len(merklePath) != len(indexes) {
+		return false, fmt.Errorf("%w: %d hashes, %d indexes", ErrPathLengthMismatch, len(merklePath), len(indexes))
+	}
+	steps := make([]pathStep, len(indexes))
+	for i, index := range indexes {
+		if index != 0 && index != 1 {
+			return false, fmt.Errorf("%w: got %d at level %d", ErrInvalidIndex, index, i)
+		}
+		steps[i].index = index
+	}
+	c := newConfig(hashStrategy, opts)
+	return verifyPath(root, leafHash, merklePath, steps, nil, &c)
+}
+
+//verifyPath hashes leafHash up the merkle path described by merklePath and steps and compares
+//the result with root. If filterRoot is set the result is first bound to it as the root node hash
+//of a tree with subtree filters.
+func verifyPath(root []byte, leafHash []byte, merklePath [][]byte, steps []pathStep, filterRoot []byte, c *config) (bool, error) {
+	if c.hashStrategy == nil {
+		return false, ErrNilHashStrategy
+	}
+	size := c.hashStrategy().Size()
+	if len(root) != size {
+		return false, fmt.Errorf("%w: root is %d bytes, expected %d", ErrInvalidHashSize, len(root), size)
+	}
+	if len(leafHash) != size {
+		return false, fmt.Errorf("%w: leaf is %d bytes, expected %d", ErrInvalidHashSize, len(leafHash), size)
+	}
+
+	current := leafHash
+	for i, sibling := range merklePath {
+		if len(sibling) != size {
+			return false, fmt.Errorf("%w: sibling %d is %d bytes, expected %d", ErrInvalidHashSize, i, len(sibling), size)
+		}
+		//A node paired with itself must have its own hash as sibling.
+		if steps[i].self && !bytes.Equal(sibling, current) {
+			return false, nil
+		}
+		var err error
+		if steps[i].index == 1 {
+			current, err = c.hashChildren(current, sibling)
+		} else {
+			current, err = c.hashChildren(sibling, current)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	if filterRoot != nil {
+		if len(filterRoot) != size {
+			return false, fmt.Errorf("%w: filter root is %d bytes, expected %d", ErrInvalidHashSize, len(filterRoot), size)
+		}
+		var err error
+		if current, err = c.bindFilterRoot(current, filterRoot); err != nil {
+			return false, err
+		}
+	}
+	return bytes.Equal(current, root), nil
+}
+
+//Proof is an inclusion proof for a single leaf. Unlike a bare merkle path it binds the leaf to
+//its position: a Proof only verifies if the leaf sits at LeafIndex of a tree built from
+//TreeSize contents, using the hash function identified by HashAlgorithm. DomainSeparation and
+//OddLeafPolicy record the options the tree was built with. Root and TreeSize describe the tree
+//the proof was generated against; they are informational and verifiers must check proofs against
+//a root and size they trust, since under OddLeafPromote and OddLeafRFC6962 different positions in
+//trees of different sizes, such as leaf 2 of 3 and leaf 1 of 2, have paths of the same shape.
+//FilterRoot is the filter root of a tree with subtree filters, which the hash of the root node is
+//bound to as described in WithSubtreeFilters, and nil otherwise.
+type Proof struct {
+	LeafIndex        int
+	TreeSize         int
+	Hashes           [][]byte
+	HashAlgorithm    crypto.Hash
+	DomainSeparation bool
+	OddLeafPolicy    OddLeafPolicy
+	Root             []byte
+	FilterRoot       []byte
+}
+
+//pathStep describes the position of a node relative to its sibling at one level of a merkle path.
+type pathStep struct {
+	index int64 // 1 if the sibling is the right child, 0 if it is the left child
+	self  bool  // the node is paired with a duplicate of itself
+}
+
+//proofPath returns the steps from the leaf at index up to the root of a tree built from size
+//contents under policy. Under OddLeafDuplicate the last node of a level with an odd number of
+//nodes is paired with itself, otherwise it is promoted and contributes no step.
+func proofPath(index, size int, policy OddLeafPolicy) []pathStep {
+	var steps []pathStep
+	if policy != OddLeafDuplicate {
+		for width := size; width > 1; width = (width + 1) / 2 {
+			if index%2 == 1 {
+				steps = append(steps, pathStep{index: 0})
+			} else if index != width-1 {
+				steps = append(steps, pathStep{index: 1})
+			}
+			index /= 2
+		}
+		return steps
+	}
+	for width := size; ; width = (width + 1) / 2 {
+		if index%2 == 1 {
+			steps = append(steps, pathStep{index: 0})
+		} else {
+			steps = append(steps, pathStep{index: 1, self: index == width-1})
+		}
+		index /= 2
+		if width <= 2 {
+			return steps
+		}
+	}
+}
+
+//hashAlgorithms lists the hash functions a hash strategy is matched against to find its crypto.Hash.
+var hashAlgorithms = []crypto.Hash{
+	crypto.MD5, crypto.SHA1, crypto.SHA224, crypto.SHA256, crypto.SHA384, crypto.SHA512,
+	crypto.SHA512_224, crypto.SHA512_256, crypto.SHA3_224, crypto.SHA3_256, crypto.SHA3_384,
+	crypto.SHA3_512, crypto.BLAKE2s_256, crypto.BLAKE2b_256, crypto.BLAKE2b_384, crypto.BLAKE2b_512,
+}
+
+//hashAlgorithm returns the crypto.Hash that produces the same hash.Hash as hashStrategy, or 0 if
+//the strategy is not one of the registered hash functions.
+func hashAlgorithm(hashStrategy func() hash.Hash) crypto.Hash {
+	h := hashStrategy()
+	for _, a := range hashAlgorithms {
+		if !a.Available() {
+			continue
+		}
+		c := a.New()
+		if reflect.TypeOf(c) == reflect.TypeOf(h) && c.Size() == h.Size() && c.BlockSize() == h.BlockSize() {
+			return a
+		}
+	}
+	return 0
+}
+
+//leafCount returns the number of contents in the tree, not counting the duplicate leaf added to
+//even out the last level.
+func (m *Tree[T]) leafCount() int {
+	if m.store != nil {
+		return m.storedLeafs
+	}
+	if n := len(m.Leafs); n > 0 && m.Leafs[n-1].dup {
+		return n - 1
+	}
+	return len(m.Leafs)
+}
+
+//GenerateProof returns the inclusion proof for the leaf at index. Returns an error if index is
+//out of range or the hash strategy of the tree is not a registered crypto.Hash.
+func (m *Tree[T]) GenerateProof(index int) (*Proof, error) {
+	if index < 0 || index >= m.leafCount() {
+		return nil, fmt.Errorf("error: leaf index %d out of range [0, %d)", index, m.leafCount())
+	}
+	algorithm := hashAlgorithm(m.hashStrategy)
+	if algorithm == 0 {
+		return nil, errors.New("error: hash strategy does not match a registered crypto.Hash")
+	}
+	hashes, _, err := m.GetMerklePathByIndex(index)
+	if err != nil {
+		return nil, err
+	}
+	return &Proof{
+		LeafIndex:        index,
+		TreeSize:         m.leafCount(),
+		Hashes:           hashes,
+		HashAlgorithm:    algorithm,
+		DomainSeparation: m.domainSeparation,
+		OddLeafPolicy:    m.oddLeafPolicy,
+		Root:             m.merkleRoot,
+		FilterRoot:       m.Root.filterHash,
+	}, nil
+}
+
+//GenerateProofs returns an inclusion proof for every leaf holding content, ordered by leaf index.
+//Returns no proofs if content is not in the tree.
+func (m *Tree[T]) GenerateProofs(content T) ([]*Proof, error) {
+	indexes, err := m.IndexesOf(content)
+	if err != nil {
+		return nil, err
+	}
+	var proofs []*Proof
+	for _, i := range indexes {
+		p, err := m.GenerateProof(i)
+		if err != nil {
+			return nil, err
+		}
+		proofs = append(proofs, p)
+	}
+	return proofs, nil
+}
+
+//Verify checks that the leaf with hash leafHash sits at position LeafIndex of the tree of
+//treeSize contents with Merkle Root root. root and treeSize must come from a trusted source;
+//a proof generated for a tree of another size is rejected. Returns an error if the proof is
+//malformed.
+func (p *Proof) Verify(root []byte, treeSize int, leafHash []byte) (bool, error) {
+	if !p.HashAlgorithm.Available() {
+		return false, fmt.Errorf("error: hash algorithm %v is not available", p.HashAlgorithm)
+	}
+	if treeSize <= 0 {
+		return false, fmt.Errorf("error: invalid tree size %d", treeSize)
+	}
+	if p.TreeSize != treeSize {
+		return false, nil
+	}
+	if p.LeafIndex < 0 || p.LeafIndex >= p.TreeSize {
+		return false, fmt.Errorf("error: leaf index %d out of range [0, %d)", p.LeafIndex, p.TreeSize)
+	}
+	c := p.config()
+	if err := c.validate(); err != nil {
+		return false, err
+	}
+	steps := proofPath(p.LeafIndex, p.TreeSize, p.OddLeafPolicy)
+	if len(p.Hashes) != len(steps) {
+		return false, fmt.Errorf("%w: %d hashes, expected %d for leaf %d of %d", ErrPathLengthMismatch, len(p.Hashes), len(steps), p.LeafIndex, p.TreeSize)
+	}
+	return verifyPath(root, leafHash, p.Hashes, steps, p.FilterRoot, &c)
+}
+
+//config returns the configuration of the tree the proof was generated from.
+func (p *Proof) config() config {
+	return config{
+		hashStrategy:     p.HashAlgorithm.New,
+		domainSeparation: p.DomainSeparation,
+		oddLeafPolicy:    p.OddLeafPolicy,
+	}
+}
+
+//VerifyContent checks that content sits at position LeafIndex of the tree of treeSize contents
+//with Merkle Root root.
+func (p *Proof) VerifyContent(root []byte, treeSize int, content Content) (bool, error) {
+	if content == nil {
+		return false, errors.New("error: content must not be nil")
+	}
+	if !p.HashAlgorithm.Available() {
+		return false, fmt.Errorf("error: hash algorithm %v is not available", p.HashAlgorithm)
+	}
+	c := p.config()
+	leafHash, err := c.hashContent(content)
+	if err != nil {
+		return false, err
+	}
+	return p.Verify(root, treeSize, leafHash)
+}
@@ -0,0 +1,357 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+//proofEncodingVersion is the version of the JSON and binary proof encodings.
+const proofEncodingVersion = 1
+
+//proofEncodingMagic starts every binary encoded Proof.
+var proofEncodingMagic = [4]byte{'M', 'R', 'K', 'P'}
+
+//Flags of the binary proof encoding.
+const (
+	proofFlagDomainSeparation = 1 << iota
+	proofFlagRoot
+	proofFlagFilterRoot
+)
+
+//Directions of a sibling in the JSON proof encoding.
+const (
+	directionLeft  = "left"
+	directionRight = "right"
+)
+
+//jsonProof is the JSON representation of a Proof. Hashes are lowercase hex strings and the
+//direction of each sibling tells whether it is hashed on the left or the right of the running
+//hash: left means hash(sibling || current), right means hash(current || sibling).
+type jsonProof struct {
+	Version          int      `json:"version"`
+	HashAlgorithm    string   `json:"hashAlgorithm"`
+	DomainSeparation bool     `json:"domainSeparation"`
+	OddLeafPolicy    string   `json:"oddLeafPolicy"`
+	LeafIndex        int      `json:"leafIndex"`
+	TreeSize         int      `json:"treeSize"`
+	Root             string   `json:"root,omitempty"`
+	FilterRoot       string   `json:"filterRoot,omitempty"`
+	Siblings         []string `json:"siblings"`
+	Directions       []string `json:"directions"`
+}
+
+//MarshalJSON implements json.Marshaler. The encoding is meant for verifiers in other languages:
+//
+//	{
+//	  "version": 1,
+//	  "hashAlgorithm": "SHA-256",
+//	  "domainSeparation": false,
+//	  "oddLeafPolicy": "duplicate",
+//	  "leafIndex": 2,
+//	  "treeSize": 3,
+//	  "root": "bdd637c5...",
+//	  "siblings": ["581d4374...", "d123f97d..."],
+//	  "directions": ["right", "left"]
+//	}
+//
+//hashAlgorithm is the crypto.Hash name of the hash function and oddLeafPolicy is one of
+//"duplicate", "promote" or "rfc6962". When domainSeparation is true leaves are hashed as
+//hash(0x00 || data) and interior nodes as hash(0x01 || left || right). The directions are
+//derived from leafIndex and treeSize and are included so that verifiers do not have to
+//implement the odd-leaf policy themselves.
+//
+//filterRoot is only present for trees with subtree filters. Verifiers then hash the result of the
+//path once more as hash(0x02 || result || filterRoot) before comparing it with the root.
+//
+//Under "duplicate" the last node of a level with an odd number of nodes is paired with a copy of
+//itself, and verifiers must enforce that pairing to accept the same proofs as Proof.Verify. At
+//level k of the path the node has index leafIndex>>k in a level of w nodes, where w is treeSize
+//at the leaves and (w+1)/2 on each level above. When that index is even and equal to w-1 the
+//node is padded: the sibling must equal the running hash and the proof must be rejected if it
+//does not, even though it may still hash to the root. At any other position a sibling equal to
+//the running hash is valid, as adjacent leaves may hold the same content.
+func (p *Proof) MarshalJSON() ([]byte, error) {
+	if p.HashAlgorithm == 0 {
+		return nil, errors.New("error: proof has no hash algorithm")
+	}
+	jp := jsonProof{
+		Version:          proofEncodingVersion,
+		HashAlgorithm:    p.HashAlgorithm.String(),
+		DomainSeparation: p.DomainSeparation,
+		OddLeafPolicy:    p.OddLeafPolicy.String(),
+		LeafIndex:        p.LeafIndex,
+		TreeSize:         p.TreeSize,
+		Root:             hex.EncodeToString(p.Root),
+		FilterRoot:       hex.EncodeToString(p.FilterRoot),
+		Siblings:         make([]string, len(p.Hashes)),
+		Directions:       make([]string, len(p.Hashes)),
+	}
+	steps := proofPath(p.LeafIndex, p.TreeSize, p.OddLeafPolicy)
+	if len(steps) != len(p.Hashes) {
+		return nil, fmt.Errorf("%w: %d hashes, expected %d for leaf %d of %d", ErrPathLengthMismatch, len(p.Hashes), len(steps), p.LeafIndex, p.TreeSize)
+	}
+	for i, h := range p.Hashes {
+		jp.Siblings[i] = hex.EncodeToString(h)
+		jp.Directions[i] = directionLeft
+		if steps[i].index == 1 {
+			jp.Directions[i] = directionRight
+		}
+	}
+	return json.Marshal(jp)
+}
+
+//UnmarshalJSON implements json.Unmarshaler for the encoding described in MarshalJSON. Returns an
+//error if the directions do not match the position of the leaf.
+func (p *Proof) UnmarshalJSON(data []byte) error {
+	var jp jsonProof
+	if err := json.Unmarshal(data, &jp); err != nil {
+		return err
+	}
+	if jp.Version != proofEncodingVersion {
+		return fmt.Errorf("error: unsupported proof encoding version %d", jp.Version)
+	}
+	algorithm, err := parseHashAlgorithm(jp.HashAlgorithm)
+	if err != nil {
+		return err
+	}
+	policy, err := parseOddLeafPolicy(jp.OddLeafPolicy)
+	if err != nil {
+		return err
+	}
+	if jp.TreeSize <= 0 || jp.LeafIndex < 0 || jp.LeafIndex >= jp.TreeSize {
+		return fmt.Errorf("error: leaf index %d out of range for tree size %d", jp.LeafIndex, jp.TreeSize)
+	}
+	steps := proofPath(jp.LeafIndex, jp.TreeSize, policy)
+	if len(jp.Siblings) != len(steps) || len(jp.Directions) != len(steps) {
+		return fmt.Errorf("%w: %d siblings and %d directions, expected %d", ErrPathLengthMismatch, len(jp.Siblings), len(jp.Directions), len(steps))
+	}
+	hashes := make([][]byte, len(jp.Siblings))
+	for i, s := range jp.Siblings {
+		if hashes[i], err = hex.DecodeString(s); err != nil {
+			return fmt.Errorf("error: sibling %d: %v", i, err)
+		}
+		expected := directionLeft
+		if steps[i].index == 1 {
+			expected = directionRight
+		}
+		if jp.Directions[i] != expected {
+			return fmt.Errorf("error: direction %d is %q, expected %q for leaf %d of %d", i, jp.Directions[i], expected, jp.LeafIndex, jp.TreeSize)
+		}
+	}
+	root, err := hex.DecodeString(jp.Root)
+	if err != nil {
+		return fmt.Errorf("error: root: %v", err)
+	}
+	if len(root) == 0 {
+		root = nil
+	}
+	filterRoot, err := hex.DecodeString(jp.FilterRoot)
+	if err != nil {
+		return fmt.Errorf("error: filter root: %v", err)
+	}
+	if len(filterRoot) == 0 {
+		filterRoot = nil
+	}
+	*p = Proof{
+		LeafIndex:        jp.LeafIndex,
+		TreeSize:         jp.TreeSize,
+		Hashes:           hashes,
+		HashAlgorithm:    algorithm,
+		DomainSeparation: jp.DomainSeparation,
+		OddLeafPolicy:    policy,
+		Root:             root,
+		FilterRoot:       filterRoot,
+	}
+	return nil
+}
+
+//MarshalBinary implements encoding.BinaryMarshaler with a compact encoding. Directions are not
+//encoded since they follow from the leaf index and tree size. All integers are big endian and
+//the layout of version 1 is:
+//
+//	magic           4 bytes "MRKP"
+//	version         uint8
+//	hash algorithm  uint32, the crypto.Hash
+//	flags           uint8, bit 0 set for domain separation, bit 1 set if a root follows, bit 2
+//	                set if a filter root follows
+//	odd leaf policy uint8
+//	hash size       uint16
+//	leaf index      uint64
+//	tree size       uint64
+//	root            hash size bytes, only if flag bit 1 is set
+//	filter root     hash size bytes, only if flag bit 2 is set
+//	sibling count   uint16
+//	siblings        hash size bytes each
+func (p *Proof) MarshalBinary() ([]byte, error) {
+	if p.HashAlgorithm == 0 {
+		return nil, errors.New("error: proof has no hash algorithm")
+	}
+	size := p.HashAlgorithm.Size()
+	var flags byte
+	if p.DomainSeparation {
+		flags |= proofFlagDomainSeparation
+	}
+	if p.Root != nil {
+		flags |= proofFlagRoot
+		if len(p.Root) != size {
+			return nil, fmt.Errorf("%w: root is %d bytes, expected %d", ErrInvalidHashSize, len(p.Root), size)
+		}
+	}
+	if p.FilterRoot != nil {
+		flags |= proofFlagFilterRoot
+		if len(p.FilterRoot) != size {
+			return nil, fmt.Errorf("%w: filter root is %d bytes, expected %d", ErrInvalidHashSize, len(p.FilterRoot), size)
+		}
+	}
+	var buf bytes.Buffer
+	buf.Write(proofEncodingMagic[:])
+	header := []interface{}{
+		uint8(proofEncodingVersion),
+		uint32(p.HashAlgorithm),
+		flags,
+		uint8(p.OddLeafPolicy),
+		uint16(size),
+		uint64(p.LeafIndex),
+		uint64(p.TreeSize),
+	}
+	for _, v := range header {
+		if err := binary.Write(&buf, binary.BigEndian, v); err != nil {
+			return nil, err
+		}
+	}
+	buf.Write(p.Root)
+	buf.Write(p.FilterRoot)
+	if err := binary.Write(&buf, binary.BigEndian, uint16(len(p.Hashes))); err != nil {
+		return nil, err
+	}
+	for i, h := range p.Hashes {
+		if len(h) != size {
+			return nil, fmt.Errorf("%w: sibling %d is %d bytes, expected %d", ErrInvalidHashSize, i, len(h), size)
+		}
+		buf.Write(h)
+	}
+	return buf.Bytes(), nil
+}
+
+//UnmarshalBinary implements encoding.BinaryUnmarshaler for the encoding described in MarshalBinary.
+func (p *Proof) UnmarshalBinary(data []byte) error {
+	r := bytes.NewReader(data)
+	var header struct {
+		Magic         [4]byte
+		Version       uint8
+		HashAlgorithm uint32
+		Flags         uint8
+		OddLeafPolicy uint8
+		HashSize      uint16
+		LeafIndex     uint64
+		TreeSize      uint64
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil || header.Magic != proofEncodingMagic {
+		return errors.New("error: data is not an encoded proof")
+	}
+	if header.Version != proofEncodingVersion {
+		return fmt.Errorf("error: unsupported proof encoding version %d", header.Version)
+	}
+	algorithm := crypto.Hash(header.HashAlgorithm)
+	if !isHashAlgorithm(algorithm) || algorithm.Size() != int(header.HashSize) {
+		return fmt.Errorf("error: invalid hash algorithm %d with hash size %d", header.HashAlgorithm, header.HashSize)
+	}
+	readHash := func() ([]byte, error) {
+		h := make([]byte, header.HashSize)
+		_, err := io.ReadFull(r, h)
+		return h, err
+	}
+	var root []byte
+	if header.Flags&proofFlagRoot != 0 {
+		var err error
+		if root, err = readHash(); err != nil {
+			return fmt.Errorf("error: truncated proof root: %v", err)
+		}
+	}
+	var filterRoot []byte
+	if header.Flags&proofFlagFilterRoot != 0 {
+		var err error
+		if filterRoot, err = readHash(); err != nil {
+			return fmt.Errorf("error: truncated proof filter root: %v", err)
+		}
+	}
+	var count uint16
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return fmt.Errorf("error: truncated proof: %v", err)
+	}
+	hashes := make([][]byte, count)
+	for i := range hashes {
+		var err error
+		if hashes[i], err = readHash(); err != nil {
+			return fmt.Errorf("error: truncated sibling %d: %v", i, err)
+		}
+	}
+	if r.Len() != 0 {
+		return errors.New("error: trailing data after encoded proof")
+	}
+	*p = Proof{
+		LeafIndex:        int(header.LeafIndex),
+		TreeSize:         int(header.TreeSize),
+		Hashes:           hashes,
+		HashAlgorithm:    algorithm,
+		DomainSeparation: header.Flags&proofFlagDomainSeparation != 0,
+		OddLeafPolicy:    OddLeafPolicy(header.OddLeafPolicy),
+		Root:             root,
+		FilterRoot:       filterRoot,
+	}
+	return nil
+}
+
+//VerifyProofJSON decodes a proof in the JSON encoding described in Proof.MarshalJSON and checks
+//that the leaf with hash leafHash is included at the recorded position of the tree of treeSize
+//contents with the trusted Merkle Root root. Returns false if the proof carries a different root
+//or tree size.
+func VerifyProofJSON(data []byte, root []byte, treeSize int, leafHash []byte) (bool, error) {
+	var p Proof
+	if err := json.Unmarshal(data, &p); err != nil {
+		return false, err
+	}
+	if p.Root != nil && !bytes.Equal(p.Root, root) {
+		return false, nil
+	}
+	return p.Verify(root, treeSize, leafHash)
+}
+
+//isHashAlgorithm reports whether a is one of the hash functions a proof may name.
+func isHashAlgorithm(a crypto.Hash) bool {
+	for _, h := range hashAlgorithms {
+		if h == a {
+			return true
+		}
+	}
+	return false
+}
+
+//parseHashAlgorithm returns the crypto.Hash with the given name, such as "SHA-256".
+func parseHashAlgorithm(name string) (crypto.Hash, error) {
+	for _, h := range hashAlgorithms {
+		if h.String() == name {
+			return h, nil
+		}
+	}
+	return 0, fmt.Errorf("error: unknown hash algorithm %q", name)
+}
+
+//parseOddLeafPolicy returns the OddLeafPolicy with the given name, such as "duplicate".
+func parseOddLeafPolicy(name string) (OddLeafPolicy, error) {
+	for _, p := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("error: unknown odd leaf policy %q", name)
+}
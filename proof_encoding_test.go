@@ -0,0 +1,196 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var (
+	_ json.Marshaler             = (*Proof)(nil)
+	_ json.Unmarshaler           = (*Proof)(nil)
+	_ encoding.BinaryMarshaler   = (*Proof)(nil)
+	_ encoding.BinaryUnmarshaler = (*Proof)(nil)
+)
+
+//goldenProofJSON is the JSON encoding of the proof for the third leaf of table[1].
+const goldenProofJSON = `{"version":1,"hashAlgorithm":"SHA-256","domainSeparation":false,"oddLeafPolicy":"duplicate","leafIndex":2,"treeSize":3,"root":"bdd637c523ed5c0eab792b986db18850c239a2e23802b36aff26bb68fb3fe008","siblings":["581d43745726e0ee62911178bfb3887c3fe295d29eeb741f0e40f91e8a70907a","d123f97da25da4b08b962e64b8042bda739950443f172132e5a50cde10e7b6bc"],"directions":["right","left"]}`
+
+func equalProofs(a, b *Proof) bool {
+	if a.LeafIndex != b.LeafIndex || a.TreeSize != b.TreeSize || a.HashAlgorithm != b.HashAlgorithm ||
+		a.DomainSeparation != b.DomainSeparation || a.OddLeafPolicy != b.OddLeafPolicy ||
+		!bytes.Equal(a.Root, b.Root) || !bytes.Equal(a.FilterRoot, b.FilterRoot) || len(a.Hashes) != len(b.Hashes) {
+		return false
+	}
+	for i := range a.Hashes {
+		if !bytes.Equal(a.Hashes[i], b.Hashes[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestProof_MarshalJSONGolden(t *testing.T) {
+	tree, err := NewTree(table[1].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := tree.GenerateProof(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != goldenProofJSON {
+		t.Errorf("error: expected %s got %s", goldenProofJSON, data)
+	}
+	leafHash := tree.Leafs[2].Hash
+	ok, err := VerifyProofJSON([]byte(goldenProofJSON), tree.MerkleRoot(), tree.Len(), leafHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("error: expected golden proof to verify")
+	}
+}
+
+func TestProof_EncodingRoundTrip(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		for _, domainSeparation := range []bool{false, true} {
+			for i := 0; i < len(table); i++ {
+				opts := []Option{WithHashStrategy(table[i].hashStrategy), WithOddLeafPolicy(policy)}
+				if domainSeparation {
+					opts = append(opts, WithDomainSeparation())
+				}
+				tree, err := NewTreeWithOptions(table[i].contents, opts...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for j, c := range table[i].contents {
+					p, err := tree.GenerateProof(j)
+					if err != nil {
+						t.Fatal(err)
+					}
+					jsonData, err := json.Marshal(p)
+					if err != nil {
+						t.Fatal(err)
+					}
+					binaryData, err := p.MarshalBinary()
+					if err != nil {
+						t.Fatal(err)
+					}
+					if len(binaryData) >= len(jsonData) {
+						t.Errorf("[%v %t case:%d] error: expected binary encoding to be smaller than JSON", policy, domainSeparation, table[i].testCaseId)
+					}
+					var fromJSON, fromBinary Proof
+					if err := json.Unmarshal(jsonData, &fromJSON); err != nil {
+						t.Fatalf("[%v %t case:%d] error: unexpected error: %v", policy, domainSeparation, table[i].testCaseId, err)
+					}
+					if err := fromBinary.UnmarshalBinary(binaryData); err != nil {
+						t.Fatalf("[%v %t case:%d] error: unexpected error: %v", policy, domainSeparation, table[i].testCaseId, err)
+					}
+					for _, decoded := range []*Proof{&fromJSON, &fromBinary} {
+						if !equalProofs(p, decoded) {
+							t.Errorf("[%v %t case:%d] error: expected proof %+v got %+v", policy, domainSeparation, table[i].testCaseId, p, decoded)
+						}
+						if ok, err := decoded.VerifyContent(tree.MerkleRoot(), tree.Len(), c); err != nil || !ok {
+							t.Errorf("[%v %t case:%d] error: expected decoded proof of leaf %d to verify: %v", policy, domainSeparation, table[i].testCaseId, j, err)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestVerifyProofJSON_RootMismatch(t *testing.T) {
+	tree, err := NewTree(table[1].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewTree(table[0].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := VerifyProofJSON([]byte(goldenProofJSON), other.MerkleRoot(), tree.Len(), tree.Leafs[2].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("error: expected proof for a different root to fail")
+	}
+	//A proof without an embedded root is checked against the trusted root alone.
+	noRoot := strings.Replace(goldenProofJSON, `"root":"bdd637c523ed5c0eab792b986db18850c239a2e23802b36aff26bb68fb3fe008",`, "", 1)
+	ok, err = VerifyProofJSON([]byte(noRoot), tree.MerkleRoot(), tree.Len(), tree.Leafs[2].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("error: expected proof without root to verify")
+	}
+	ok, err = VerifyProofJSON([]byte(goldenProofJSON), tree.MerkleRoot(), tree.Len(), tree.Leafs[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("error: expected proof of a different leaf to fail")
+	}
+}
+
+func TestProof_UnmarshalJSONErrors(t *testing.T) {
+	for name, bad := range map[string]string{
+		"syntax":     `{"version":`,
+		"version":    strings.Replace(goldenProofJSON, `"version":1`, `"version":2`, 1),
+		"algorithm":  strings.Replace(goldenProofJSON, `"SHA-256"`, `"SHA-1024"`, 1),
+		"policy":     strings.Replace(goldenProofJSON, `"duplicate"`, `"triplicate"`, 1),
+		"index":      strings.Replace(goldenProofJSON, `"leafIndex":2`, `"leafIndex":3`, 1),
+		"directions": strings.Replace(goldenProofJSON, `["right","left"]`, `["left","left"]`, 1),
+		"count":      strings.Replace(goldenProofJSON, `["right","left"]`, `["right"]`, 1),
+		"hex":        strings.Replace(goldenProofJSON, `"581d`, `"zz1d`, 1),
+		"root":       strings.Replace(goldenProofJSON, `"bdd6`, `"zzd6`, 1),
+	} {
+		var p Proof
+		if err := json.Unmarshal([]byte(bad), &p); err == nil {
+			t.Errorf("[%s] error: expected error", name)
+		}
+	}
+}
+
+func TestProof_UnmarshalBinaryErrors(t *testing.T) {
+	tree, err := NewTree(table[1].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := tree.GenerateProof(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, bad := range map[string][]byte{
+		"empty":     nil,
+		"magic":     append([]byte("XXXX"), data[4:]...),
+		"version":   append(append(append([]byte{}, data[:4]...), 99), data[5:]...),
+		"algorithm": append(append(append([]byte{}, data[:5]...), 0, 0, 0, 99), data[9:]...),
+		"truncated": data[:len(data)-1],
+		"trailing":  append(append([]byte{}, data...), 0),
+	} {
+		var decoded Proof
+		if err := decoded.UnmarshalBinary(bad); err == nil {
+			t.Errorf("[%s] error: expected error", name)
+		}
+	}
+	p.Root = p.Root[:4]
+	if _, err := p.MarshalBinary(); err == nil {
+		t.Error("error: expected error encoding a truncated root")
+	}
+}
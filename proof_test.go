@@ -0,0 +1,285 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyMerklePath(t *testing.T) {
+	for i := 0; i < len(table); i++ {
+		tree, err := NewTreeWithHashStrategy(table[i].contents, table[i].hashStrategy)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseId, err)
+		}
+		for j, c := range table[i].contents {
+			merklePath, index, err := tree.GetMerklePath(c)
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseId, err)
+			}
+			ok, err := VerifyMerklePath(tree.MerkleRoot(), c, merklePath, index, table[i].hashStrategy)
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseId, err)
+			}
+			if !ok {
+				t.Errorf("[case:%d] error: expected content %d to verify", table[i].testCaseId, j)
+			}
+		}
+		merklePath, index, err := tree.GetMerklePath(table[i].contents[0])
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseId, err)
+		}
+		ok, err := VerifyMerklePath(tree.MerkleRoot(), table[i].notInContents, merklePath, index, table[i].hashStrategy)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseId, err)
+		}
+		if ok {
+			t.Errorf("[case:%d] error: expected content not in tree to fail verification", table[i].testCaseId)
+		}
+	}
+}
+
+func TestVerifyMerklePathHash_Errors(t *testing.T) {
+	tree, err := NewTree(table[0].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merklePath, index, err := tree.GetMerklePath(table[0].contents[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := tree.Leafs[0].Hash
+	root := tree.MerkleRoot()
+
+	if _, err := VerifyMerklePathHash(root, leaf, merklePath, index[:1], table[0].hashStrategy); !errors.Is(err, ErrPathLengthMismatch) {
+		t.Errorf("error: expected ErrPathLengthMismatch, got %v", err)
+	}
+	if _, err := VerifyMerklePathHash(root[:4], leaf, merklePath, index, table[0].hashStrategy); !errors.Is(err, ErrInvalidHashSize) {
+		t.Errorf("error: expected ErrInvalidHashSize for root, got %v", err)
+	}
+	if _, err := VerifyMerklePathHash(root, leaf[:4], merklePath, index, table[0].hashStrategy); !errors.Is(err, ErrInvalidHashSize) {
+		t.Errorf("error: expected ErrInvalidHashSize for leaf, got %v", err)
+	}
+	badPath := [][]byte{merklePath[0], merklePath[1][:4]}
+	if _, err := VerifyMerklePathHash(root, leaf, badPath, index, table[0].hashStrategy); !errors.Is(err, ErrInvalidHashSize) {
+		t.Errorf("error: expected ErrInvalidHashSize for sibling, got %v", err)
+	}
+	if _, err := VerifyMerklePathHash(root, leaf, merklePath, []int64{2, 1}, table[0].hashStrategy); !errors.Is(err, ErrInvalidIndex) {
+		t.Errorf("error: expected ErrInvalidIndex, got %v", err)
+	}
+	if _, err := VerifyMerklePathHash(root, leaf, merklePath, index, nil); !errors.Is(err, ErrNilHashStrategy) {
+		t.Errorf("error: expected ErrNilHashStrategy, got %v", err)
+	}
+	if _, err := VerifyMerklePathHash(root, leaf, merklePath, index, table[5].hashStrategy); !errors.Is(err, ErrInvalidHashSize) {
+		t.Errorf("error: expected ErrInvalidHashSize for mismatched hash strategy, got %v", err)
+	}
+}
+
+func TestMerkleTree_GenerateProof(t *testing.T) {
+	for i := 0; i < len(table); i++ {
+		tree, err := NewTreeWithHashStrategy(table[i].contents, table[i].hashStrategy)
+		if err != nil {
+			t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseId, err)
+		}
+		for j, c := range table[i].contents {
+			p, err := tree.GenerateProof(j)
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseId, err)
+			}
+			if p.TreeSize != len(table[i].contents) {
+				t.Errorf("[case:%d] error: expected tree size %d got %d", table[i].testCaseId, len(table[i].contents), p.TreeSize)
+			}
+			ok, err := p.VerifyContent(tree.MerkleRoot(), tree.Len(), c)
+			if err != nil {
+				t.Fatalf("[case:%d] error: unexpected error: %v", table[i].testCaseId, err)
+			}
+			if !ok {
+				t.Errorf("[case:%d] error: expected proof for leaf %d to verify", table[i].testCaseId, j)
+			}
+		}
+		if _, err := tree.GenerateProof(len(table[i].contents)); err == nil {
+			t.Errorf("[case:%d] error: expected error for out of range index", table[i].testCaseId)
+		}
+	}
+}
+
+func TestProof_VerifyBindsPosition(t *testing.T) {
+	tree, err := NewTree(table[2].contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.MerkleRoot()
+	last := len(table[2].contents) - 1
+	p, err := tree.GenerateProof(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf := tree.Leafs[last].Hash
+
+	//The duplicate padding leaf shares the path of the last leaf but is not part of the tree.
+	moved := *p
+	moved.LeafIndex = last + 1
+	if _, err := moved.Verify(root, tree.Len(), leaf); err == nil {
+		t.Error("error: expected error for leaf index beyond tree size")
+	}
+
+	shrunk := *p
+	shrunk.TreeSize = 2
+	if _, err := shrunk.Verify(root, 2, leaf); err == nil {
+		t.Error("error: expected error for tree size smaller than leaf index")
+	}
+	if ok, err := shrunk.Verify(root, tree.Len(), leaf); err != nil || ok {
+		t.Errorf("error: expected proof for another tree size to fail: %v", err)
+	}
+
+	p, err = tree.GenerateProof(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapped := *p
+	swapped.LeafIndex = 1
+	ok, err := swapped.Verify(root, tree.Len(), tree.Leafs[0].Hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("error: expected proof with wrong leaf index to fail")
+	}
+}
+
+func TestProof_DuplicateContent(t *testing.T) {
+	contents := []Content{
+		TestSHA256Content{x: "Hello"},
+		TestSHA256Content{x: "Hello"},
+		TestSHA256Content{x: "Hi"},
+	}
+	tree, err := NewTree(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, c := range contents {
+		p, err := tree.GenerateProof(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := p.VerifyContent(tree.MerkleRoot(), tree.Len(), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("error: expected proof for leaf %d to verify", j)
+		}
+	}
+}
+
+func TestMerkleTree_GenerateProofOddLeafPolicies(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		for i := 0; i < len(table); i++ {
+			tree, err := NewTreeWithOptions(table[i].contents, WithHashStrategy(table[i].hashStrategy), WithOddLeafPolicy(policy))
+			if err != nil {
+				t.Fatalf("[%v case:%d] error: unexpected error: %v", policy, table[i].testCaseId, err)
+			}
+			for j, c := range table[i].contents {
+				p, err := tree.GenerateProof(j)
+				if err != nil {
+					t.Fatalf("[%v case:%d] error: unexpected error: %v", policy, table[i].testCaseId, err)
+				}
+				ok, err := p.VerifyContent(tree.MerkleRoot(), tree.Len(), c)
+				if err != nil {
+					t.Fatalf("[%v case:%d] error: unexpected error: %v", policy, table[i].testCaseId, err)
+				}
+				if !ok {
+					t.Errorf("[%v case:%d] error: expected proof for leaf %d to verify", policy, table[i].testCaseId, j)
+				}
+				merklePath, index, err := tree.GetMerklePath(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ok, err = VerifyMerklePath(tree.MerkleRoot(), c, merklePath, index, table[i].hashStrategy, WithOddLeafPolicy(policy))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					t.Errorf("[%v case:%d] error: expected merkle path for leaf %d to verify", policy, table[i].testCaseId, j)
+				}
+			}
+		}
+	}
+}
+
+func TestProof_VerifyRejectsPromotedPositions(t *testing.T) {
+	tree, err := NewTreeWithOptions(table[2].contents, WithOddLeafPolicy(OddLeafPromote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := len(table[2].contents) - 1
+	p, err := tree.GenerateProof(last)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Under promotion a tree of one more leaf gives the last leaf a longer path.
+	grown := *p
+	grown.TreeSize++
+	if _, err := grown.Verify(tree.MerkleRoot(), grown.TreeSize, tree.Leafs[last].Hash); !errors.Is(err, ErrPathLengthMismatch) {
+		t.Errorf("error: expected ErrPathLengthMismatch, got %v", err)
+	}
+
+	//Leaf 2 of 3 and leaf 1 of 2 have paths of the same shape, so only the trusted size tells
+	//them apart.
+	for _, policy := range []OddLeafPolicy{OddLeafPromote, OddLeafRFC6962} {
+		tree, err := NewTreeWithOptions(numberedContents(3), WithOddLeafPolicy(policy), WithDomainSeparation())
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := tree.GenerateProof(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forged := *p
+		forged.LeafIndex, forged.TreeSize = 1, 2
+		if ok, err := forged.Verify(tree.MerkleRoot(), forged.TreeSize, tree.Leafs[2].Hash); err != nil || !ok {
+			t.Fatalf("[%v] error: expected the forged position to hash to the root: %v", policy, err)
+		}
+		if ok, err := forged.Verify(tree.MerkleRoot(), tree.Len(), tree.Leafs[2].Hash); err != nil || ok {
+			t.Errorf("[%v] error: expected proof for another tree size to fail: %v", policy, err)
+		}
+	}
+}
+
+func TestMerkleTree_GenerateProofs(t *testing.T) {
+	repeated := TestSHA256Content{x: "Hello"}
+	contents := []Content{repeated, TestSHA256Content{x: "Hi"}, repeated, repeated, TestSHA256Content{x: "Hey"}}
+	tree, err := NewTree(contents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proofs, err := tree.GenerateProofs(repeated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []int{0, 2, 3}
+	if len(proofs) != len(expected) {
+		t.Fatalf("error: expected %d proofs got %d", len(expected), len(proofs))
+	}
+	for k, p := range proofs {
+		if p.LeafIndex != expected[k] {
+			t.Errorf("error: expected proof for leaf %d got %d", expected[k], p.LeafIndex)
+		}
+		ok, err := p.VerifyContent(tree.MerkleRoot(), tree.Len(), repeated)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			t.Errorf("error: expected proof for leaf %d to verify", p.LeafIndex)
+		}
+	}
+	proofs, err = tree.GenerateProofs(TestSHA256Content{x: "NotInTestTable"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(proofs) != 0 {
+		t.Errorf("error: expected no proofs got %d", len(proofs))
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+//ErrUnsorted is returned when updating a leaf of a sorted tree would break the order of its keys.
+var ErrUnsorted = errors.New("error: content breaks the order of a sorted tree")
+
+//WithSortedLeaves sorts the leaves of the tree by their hash before the tree is built. For trees
+//built with WithDomainSeparation this is the prefixed hash, the one returned by LeafIndex. Append
+//inserts new content at its sorted position and UpdateLeaf rejects content that does not fit
+//between its neighbours. A sorted tree that also uses WithDomainSeparation can prove that content
+//is absent with NonInclusionProof.
+func WithSortedLeaves() Option {
+	return func(c *config) {
+		c.sorted = true
+	}
+}
+
+//WithSortKey sorts the leaves of the tree by the key returned by sortKey for their content,
+//compared with bytes.Compare, like WithSortedLeaves. Leaves with equal keys keep their order.
+func WithSortKey(sortKey func(Content) ([]byte, error)) Option {
+	return func(c *config) {
+		c.sorted = true
+		c.sortKey = sortKey
+	}
+}
+
+//keyOf returns the sort key of content whose leaf hash is hash.
+func (c *config) keyOf(content Content, hash []byte) ([]byte, error) {
+	if c.sortKey == nil {
+		return hash, nil
+	}
+	if content == nil {
+		return nil, errors.New("error: cannot compute the sort key of a leaf without content")
+	}
+	return c.sortKey(content)
+}
+
+//leafKey returns the sort key of leaf l.
+func (m *Tree[T]) leafKey(l *NodeOf[T]) ([]byte, error) {
+	if l.empty && m.sortKey != nil {
+		return nil, errors.New("error: cannot compute the sort key of a leaf without content")
+	}
+	return m.keyOf(m.funcs.content(l.C), l.Hash)
+}
+
+//leafKeys is a sort.Interface ordering leaves by their keys.
+type leafKeys[T any] struct {
+	leafs []*NodeOf[T]
+	keys  [][]byte
+}
+
+func (l leafKeys[T]) Len() int           { return len(l.leafs) }
+func (l leafKeys[T]) Less(i, j int) bool { return bytes.Compare(l.keys[i], l.keys[j]) < 0 }
+func (l leafKeys[T]) Swap(i, j int) {
+	l.leafs[i], l.leafs[j] = l.leafs[j], l.leafs[i]
+	l.keys[i], l.keys[j] = l.keys[j], l.keys[i]
+}
+
+//sortLeafs sorts leafs by their keys, keeping the order of equal keys, and returns the keys.
+func (m *Tree[T]) sortLeafs(leafs []*NodeOf[T]) ([][]byte, error) {
+	keys := make([][]byte, len(leafs))
+	for i, l := range leafs {
+		var err error
+		if keys[i], err = m.leafKey(l); err != nil {
+			return nil, err
+		}
+	}
+	sort.Stable(leafKeys[T]{leafs, keys})
+	return keys, nil
+}
+
+//searchLeafs returns the first position in [lo, hi) whose leaf key is greater than key, or after
+//it if strict is false, or hi if there is none.
+func (m *Tree[T]) searchLeafs(lo, hi int, key []byte, strict bool) (int, error) {
+	var err error
+	i := sort.Search(hi-lo, func(i int) bool {
+		k, kerr := m.leafKey(m.Leafs[lo+i])
+		if kerr != nil {
+			err = kerr
+			return true
+		}
+		if strict {
+			return bytes.Compare(k, key) > 0
+		}
+		return bytes.Compare(k, key) >= 0
+	})
+	if err != nil {
+		return 0, err
+	}
+	return lo + i, nil
+}
+
+//appendSorted inserts leafs at their sorted positions and rebuilds the tree from the first of them.
+func (m *Tree[T]) appendSorted(leafs []*NodeOf[T]) error {
+	keys, err := m.sortLeafs(leafs)
+	if err != nil {
+		return err
+	}
+	n := m.leafCount()
+	level := make([]*NodeOf[T], 0, n+len(leafs)+1)
+	start, next := -1, 0
+	for i, l := range leafs {
+		j, err := m.searchLeafs(next, n, keys[i], true)
+		if err != nil {
+			return err
+		}
+		if start < 0 {
+			start = j
+		}
+		level = append(append(level, m.Leafs[next:j]...), l)
+		next = j
+	}
+	level = append(level, m.Leafs[next:n]...)
+	levels := append([][]*NodeOf[T]{level}, m.levels[1:]...)
+	if err := m.rebuild(levels, start, level[start:]); err != nil {
+		return err
+	}
+	m.reindexLeafs()
+	return nil
+}
+
+//checkOrder returns ErrUnsorted if content with leaf hash hash does not fit at index of the tree.
+func (m *Tree[T]) checkOrder(index int, content T, hash []byte) error {
+	key, err := m.keyOf(m.funcs.content(content), hash)
+	if err != nil {
+		return err
+	}
+	for _, i := range []int{index - 1, index + 1} {
+		if i < 0 || i >= m.leafCount() {
+			continue
+		}
+		k, err := m.leafKey(m.Leafs[i])
+		if err != nil {
+			return err
+		}
+		if cmp := bytes.Compare(k, key); (i < index && cmp > 0) || (i > index && cmp < 0) {
+			return fmt.Errorf("%w: leaf %d", ErrUnsorted, index)
+		}
+	}
+	return nil
+}
+
+//NonInclusionProof proves that content is absent from a sorted tree by proving the inclusion of
+//the two consecutive leaves whose keys surround its key. Left is nil if the key sorts before the
+//first leaf and Right is nil if it sorts after the last one. The verifier recomputes the leaf
+//hashes and keys of the neighbours from LeftContent and RightContent.
+type NonInclusionProof struct {
+	Left         *Proof
+	Right        *Proof
+	LeftContent  Content
+	RightContent Content
+}
+
+//errNonInclusionDomainSeparation is returned for non-inclusion proofs of trees without domain
+//separation, whose interior nodes could be passed off as neighbouring leaves.
+var errNonInclusionDomainSeparation = errors.New("error: non-inclusion proofs require domain separation")
+
+//NonInclusionProof returns the proof that content is not in the tree. Returns an error if the tree
+//is not sorted, does not use domain separation, holds a leaf with the same key as content or a
+//neighbour without content, such as a leaf decoded by UnmarshalBinary.
+func (m *Tree[T]) NonInclusionProof(content T) (*NonInclusionProof, error) {
+	if !m.sorted {
+		return nil, errors.New("error: non-inclusion proofs require a sorted tree")
+	}
+	if !m.domainSeparation {
+		return nil, errNonInclusionDomainSeparation
+	}
+	hash, err := m.hashValue(content)
+	if err != nil {
+		return nil, err
+	}
+	key, err := m.keyOf(m.funcs.content(content), hash)
+	if err != nil {
+		return nil, err
+	}
+	n := m.leafCount()
+	i, err := m.searchLeafs(0, n, key, false)
+	if err != nil {
+		return nil, err
+	}
+	if i < n {
+		k, err := m.leafKey(m.Leafs[i])
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(k, key) {
+			return nil, fmt.Errorf("error: leaf %d has the key of the content", i)
+		}
+	}
+	for _, j := range []int{i - 1, i} {
+		if j >= 0 && j < n && m.Leafs[j].empty {
+			return nil, fmt.Errorf("error: neighbour leaf %d has no content", j)
+		}
+	}
+	p := &NonInclusionProof{}
+	if i > 0 {
+		if p.Left, err = m.GenerateProof(i - 1); err != nil {
+			return nil, err
+		}
+		p.LeftContent = m.funcs.content(m.Leafs[i-1].C)
+	}
+	if i < n {
+		if p.Right, err = m.GenerateProof(i); err != nil {
+			return nil, err
+		}
+		p.RightContent = m.funcs.content(m.Leafs[i].C)
+	}
+	return p, nil
+}
+
+//Verify checks that content is absent from the sorted tree of treeSize contents with Merkle Root
+//root: the neighbours must be included in the tree, be consecutive leaves, and have keys that
+//surround the key of content. root and treeSize must come from a trusted source. The leaf hashes
+//and keys of the neighbours are computed from their content. keyFunc must be the sort key of the
+//tree, or nil for trees sorted by leaf hash. Returns an error if the proof is malformed or the
+//tree does not use domain separation.
+func (p *NonInclusionProof) Verify(root []byte, treeSize int, content Content, keyFunc func(Content) ([]byte, error)) (bool, error) {
+	if p.Left == nil && p.Right == nil {
+		return false, errors.New("error: non-inclusion proof has no neighbours")
+	}
+	if p.Left != nil && p.Right != nil {
+		if p.Left.HashAlgorithm != p.Right.HashAlgorithm || p.Left.DomainSeparation != p.Right.DomainSeparation ||
+			p.Left.OddLeafPolicy != p.Right.OddLeafPolicy {
+			return false, errors.New("error: neighbour proofs use different options")
+		}
+	}
+
+	//The neighbours must be consecutive leaves of the tree; Proof.Verify checks their tree size.
+	switch {
+	case p.Left != nil && p.Right != nil:
+		if p.Left.LeafIndex+1 != p.Right.LeafIndex {
+			return false, nil
+		}
+	case p.Left != nil:
+		if p.Left.LeafIndex != treeSize-1 {
+			return false, nil
+		}
+	case p.Right != nil:
+		if p.Right.LeafIndex != 0 {
+			return false, nil
+		}
+	}
+
+	proof := p.Left
+	if proof == nil {
+		proof = p.Right
+	}
+	if !proof.HashAlgorithm.Available() {
+		return false, fmt.Errorf("error: hash algorithm %v is not available", proof.HashAlgorithm)
+	}
+	if !proof.DomainSeparation {
+		return false, errNonInclusionDomainSeparation
+	}
+	c := proof.config()
+	c.sortKey = keyFunc
+	hash, err := c.hashContent(content)
+	if err != nil {
+		return false, err
+	}
+	key, err := c.keyOf(content, hash)
+	if err != nil {
+		return false, err
+	}
+
+	neighbours := []struct {
+		proof   *Proof
+		content Content
+		cmp     int
+	}{
+		{p.Left, p.LeftContent, -1},
+		{p.Right, p.RightContent, 1},
+	}
+	for _, nb := range neighbours {
+		if nb.proof == nil {
+			continue
+		}
+		if nb.content == nil {
+			return false, errors.New("error: non-inclusion proof has a neighbour without content")
+		}
+		leafHash, err := c.hashContent(nb.content)
+		if err != nil {
+			return false, err
+		}
+		ok, err := nb.proof.Verify(root, treeSize, leafHash)
+		if err != nil || !ok {
+			return false, err
+		}
+		k, err := c.keyOf(nb.content, leafHash)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Compare(k, key) != nb.cmp {
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,236 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+)
+
+//contentKey is a sort key that orders TestSHA256Content by its value.
+func contentKey(c Content) ([]byte, error) {
+	t, ok := c.(TestSHA256Content)
+	if !ok {
+		return nil, errors.New("error: value is not of type TestSHA256Content")
+	}
+	return []byte(t.x), nil
+}
+
+//sortedContents returns n contents named "item-XXX" with even numbers, in shuffled order.
+func sortedContents(n int) []Content {
+	cs := make([]Content, n)
+	for i := range cs {
+		cs[(i*7)%n] = TestSHA256Content{x: fmt.Sprintf("item-%03d", 2*i)}
+	}
+	return cs
+}
+
+func TestMerkleTree_SortedLeaves(t *testing.T) {
+	for _, domainSeparation := range []bool{false, true} {
+		opts := []Option{WithSortedLeaves()}
+		if domainSeparation {
+			opts = append(opts, WithDomainSeparation())
+		}
+		cs := sortedContents(11)
+		tree, err := NewTreeWithOptions(cs, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 1; i < tree.leafCount(); i++ {
+			if bytes.Compare(tree.Leafs[i-1].Hash, tree.Leafs[i].Hash) > 0 {
+				t.Fatalf("[%t] error: expected leaf %d to sort before leaf %d", domainSeparation, i-1, i)
+			}
+		}
+		for _, c := range cs {
+			if ok, err := tree.VerifyContent(c); err != nil || !ok {
+				t.Errorf("[%t] error: expected content to verify: %v", domainSeparation, err)
+			}
+			if _, err := tree.NonInclusionProof(c); err == nil {
+				t.Errorf("[%t] error: expected no non-inclusion proof for present content", domainSeparation)
+			}
+		}
+		if !domainSeparation {
+			if _, err := tree.NonInclusionProof(TestSHA256Content{x: "absent"}); !errors.Is(err, errNonInclusionDomainSeparation) {
+				t.Errorf("error: expected errNonInclusionDomainSeparation got %v", err)
+			}
+			continue
+		}
+		for i := 0; i < 40; i++ {
+			absent := TestSHA256Content{x: fmt.Sprintf("absent-%d", i)}
+			p, err := tree.NonInclusionProof(absent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err := p.Verify(tree.MerkleRoot(), tree.Len(), absent, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Errorf("[%t absent:%d] error: expected non-inclusion proof to verify", domainSeparation, i)
+			}
+			if ok, err := p.Verify(tree.MerkleRoot(), tree.Len(), cs[i%len(cs)], nil); err != nil || ok {
+				t.Errorf("[%t absent:%d] error: expected proof not to cover present content: %v", domainSeparation, i, err)
+			}
+			if ok, err := p.Verify(tree.MerkleRoot(), tree.Len()+1, absent, nil); err != nil || ok {
+				t.Errorf("[%t absent:%d] error: expected proof for another tree size to fail: %v", domainSeparation, i, err)
+			}
+		}
+	}
+}
+
+func TestNonInclusionProof_ForgedNeighbours(t *testing.T) {
+	for _, domainSeparation := range []bool{false, true} {
+		opts := []Option{WithSortedLeaves()}
+		if domainSeparation {
+			opts = append(opts, WithDomainSeparation())
+		}
+		tree, err := NewTreeWithOptions(sortedContents(11), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := tree.GenerateProof(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		//The children of the root pass as the two leaves of a tree of size 2 with the same root,
+		//between which any key may fall.
+		left, right := *p, *p
+		left.LeafIndex, left.TreeSize, left.Hashes = 0, 2, [][]byte{tree.Root.Right.Hash}
+		right.LeafIndex, right.TreeSize, right.Hashes = 1, 2, [][]byte{tree.Root.Left.Hash}
+		if ok, err := left.Verify(tree.MerkleRoot(), 2, tree.Root.Left.Hash); err != nil || !ok {
+			t.Fatalf("[%t] error: expected the children of the root to form a tree of size 2: %v", domainSeparation, err)
+		}
+		//Pick present content whose hash sorts between the children, as the attack requires.
+		present := tree.Leafs[0].C
+		for _, l := range tree.Leafs {
+			if bytes.Compare(tree.Root.Left.Hash, l.Hash) < 0 && bytes.Compare(l.Hash, tree.Root.Right.Hash) < 0 {
+				present = l.C
+			}
+		}
+		forged := &NonInclusionProof{Left: &left, Right: &right, LeftContent: tree.Leafs[0].C, RightContent: tree.Leafs[10].C}
+		for _, size := range []int{2, tree.Len()} {
+			if ok, err := forged.Verify(tree.MerkleRoot(), size, present, nil); ok {
+				t.Errorf("[%t size:%d] error: expected forged proof of absence to fail: %v", domainSeparation, size, err)
+			}
+		}
+	}
+}
+
+func TestMerkleTree_SortKey(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote} {
+		cs := sortedContents(9)
+		tree, err := NewTreeWithOptions(cs, WithSortKey(contentKey), WithOddLeafPolicy(policy), WithDomainSeparation())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < tree.leafCount(); i++ {
+			if expected := fmt.Sprintf("item-%03d", 2*i); tree.Leafs[i].C.(TestSHA256Content).x != expected {
+				t.Errorf("[%v] error: expected leaf %d to be %s got %s", policy, i, expected, tree.Leafs[i].C)
+			}
+		}
+		for i := -1; i < 2*len(cs); i += 2 {
+			absent := TestSHA256Content{x: fmt.Sprintf("item-%03d", i)}
+			if i < 0 {
+				absent.x = "a"
+			}
+			p, err := tree.NonInclusionProof(absent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (p.Left == nil) != (i < 0) || (p.Right == nil) != (i > 2*len(cs)-2) {
+				t.Errorf("[%v %s] error: unexpected neighbours", policy, absent.x)
+			}
+			ok, err := p.Verify(tree.MerkleRoot(), tree.Len(), absent, contentKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Errorf("[%v %s] error: expected non-inclusion proof to verify", policy, absent.x)
+			}
+			//Neighbours that are not consecutive leaves must be rejected.
+			if p.Left != nil && p.Right != nil && p.Right.LeafIndex+1 < p.Right.TreeSize {
+				far, err := tree.GenerateProof(p.Right.LeafIndex + 1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				forged := *p
+				forged.Right, forged.RightContent = far, tree.Leafs[far.LeafIndex].C
+				if ok, err := forged.Verify(tree.MerkleRoot(), tree.Len(), absent, contentKey); err != nil || ok {
+					t.Errorf("[%v %s] error: expected gap between neighbours to fail: %v", policy, absent.x, err)
+				}
+			}
+			//Swapped neighbour content must not verify against the proofs.
+			if p.Left != nil && p.Right != nil {
+				swapped := *p
+				swapped.LeftContent = TestSHA256Content{x: "a"}
+				if ok, err := swapped.Verify(tree.MerkleRoot(), tree.Len(), absent, contentKey); err != nil || ok {
+					t.Errorf("[%v %s] error: expected forged neighbour to fail: %v", policy, absent.x, err)
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleTree_SortedMutations(t *testing.T) {
+	all := sortedContents(20)
+	tree, err := NewTreeWithOptions(all[:13], WithSortKey(contentKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Append(all[13:]...); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := NewTreeWithOptions(all, WithSortKey(contentKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected appended root %x got %x", expected.MerkleRoot(), tree.MerkleRoot())
+	}
+	v, err := tree.VerifyTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v {
+		t.Error("error: expected tree to be valid")
+	}
+	for _, c := range all {
+		if ok, err := tree.VerifyContent(c); err != nil || !ok {
+			t.Errorf("error: expected content to verify after append: %v", err)
+		}
+	}
+
+	//item-005 fits between item-004 and item-006, item-100 does not.
+	if _, _, err := tree.UpdateLeaf(2, TestSHA256Content{x: "item-005"}); err != nil {
+		t.Error(err)
+	}
+	if _, _, err := tree.UpdateLeaf(2, TestSHA256Content{x: "item-100"}); !errors.Is(err, ErrUnsorted) {
+		t.Errorf("error: expected ErrUnsorted got %v", err)
+	}
+	if err := tree.RemoveLeaf(5); err != nil {
+		t.Fatal(err)
+	}
+	keys := make([]string, tree.leafCount())
+	for i := range keys {
+		keys[i] = tree.Leafs[i].C.(TestSHA256Content).x
+	}
+	if !sort.StringsAreSorted(keys) {
+		t.Errorf("error: expected leaves to stay sorted got %v", keys)
+	}
+
+	unsorted, err := NewTree(all)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unsorted.NonInclusionProof(TestSHA256Content{x: "absent"}); err == nil {
+		t.Error("error: expected error for an unsorted tree")
+	}
+	failing := func(Content) ([]byte, error) { return nil, errFailingContent }
+	if _, err := NewTreeWithOptions(all, WithSortKey(failing)); !errors.Is(err, errFailingContent) {
+		t.Errorf("error: expected sort key error got %v", err)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+//SparseMerkleTree is a Merkle Tree with a leaf for every possible hash of a key. A key is stored
+//in the leaf at the path given by the bits of its hash, most significant bit first, so the depth
+//of the tree is the size of the hash in bits (256 for sha256). Almost all leaves are empty and
+//only the nodes above stored keys are kept; every other node has the precomputed hash of an empty
+//subtree of its height. This allows proving that a key is absent as well as present.
+//
+//Leaves are hashed as hash(0x00 || path || value) and interior nodes as hash(0x01 || left || right)
+//where path is the hash of the key. An empty leaf is all zero bytes.
+type SparseMerkleTree struct {
+	depth  int
+	empty  [][]byte
+	nodes  map[string][]byte
+	values map[string][]byte
+	root   []byte
+	config
+}
+
+//SparseProof is a proof of inclusion or non-inclusion of a key in a SparseMerkleTree. Siblings
+//holds the hashes of the siblings along the path of the key, from the leaf up, that are not the
+//hash of an empty subtree. Bit i of Bitmap, least significant bit of the first byte first, is set
+//if the sibling at height i is in Siblings.
+type SparseProof struct {
+	Bitmap   []byte
+	Siblings [][]byte
+}
+
+//NewSparseMerkleTree creates a new empty Sparse Merkle Tree using the provided hash strategy for
+//keys, leaves and interior nodes.
+func NewSparseMerkleTree(hashStrategy func() hash.Hash) (*SparseMerkleTree, error) {
+	if hashStrategy == nil {
+		return nil, ErrNilHashStrategy
+	}
+	c := config{hashStrategy: hashStrategy, domainSeparation: true}
+	empty, err := sparseEmptyHashes(&c)
+	if err != nil {
+		return nil, err
+	}
+	depth := len(empty) - 1
+	return &SparseMerkleTree{
+		depth:  depth,
+		empty:  empty,
+		nodes:  make(map[string][]byte),
+		values: make(map[string][]byte),
+		root:   empty[depth],
+		config: c,
+	}, nil
+}
+
+//sparseEmptyHashes returns the hashes of the empty subtrees of each height, from an empty leaf at
+//height 0 to the empty tree.
+func sparseEmptyHashes(c *config) ([][]byte, error) {
+	depth := c.hashStrategy().Size() * 8
+	empty := make([][]byte, depth+1)
+	empty[0] = make([]byte, depth/8)
+	for h := 0; h < depth; h++ {
+		var err error
+		if empty[h+1], err = c.hashChildren(empty[h], empty[h]); err != nil {
+			return nil, err
+		}
+	}
+	return empty, nil
+}
+
+//MerkleRoot returns the Merkle Root of the tree.
+func (s *SparseMerkleTree) MerkleRoot() []byte {
+	return s.root
+}
+
+//Len returns the number of keys stored in the tree.
+func (s *SparseMerkleTree) Len() int {
+	return len(s.values)
+}
+
+//Get returns the value stored for key and whether the key is present.
+func (s *SparseMerkleTree) Get(key []byte) ([]byte, bool, error) {
+	path, err := s.keyPath(key)
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := s.values[string(path)]
+	return value, ok, nil
+}
+
+//Set stores value for key, replacing the current value, and rehashes the nodes above its leaf.
+//The value must not be nil; use Delete to remove a key.
+func (s *SparseMerkleTree) Set(key, value []byte) error {
+	if value == nil {
+		return errors.New("error: value must not be nil")
+	}
+	path, err := s.keyPath(key)
+	if err != nil {
+		return err
+	}
+	leaf, err := s.hashLeaf(append(append([]byte{}, path...), value...))
+	if err != nil {
+		return err
+	}
+	if err := s.update(path, leaf); err != nil {
+		return err
+	}
+	s.values[string(path)] = append([]byte{}, value...)
+	return nil
+}
+
+//Delete removes key from the tree and reports whether it was present.
+func (s *SparseMerkleTree) Delete(key []byte) (bool, error) {
+	path, err := s.keyPath(key)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := s.values[string(path)]; !ok {
+		return false, nil
+	}
+	if err := s.update(path, s.empty[0]); err != nil {
+		return false, err
+	}
+	delete(s.values, string(path))
+	return true, nil
+}
+
+//Prove returns the proof for key, which shows inclusion of its value if the key is present and
+//non-inclusion otherwise.
+func (s *SparseMerkleTree) Prove(key []byte) (*SparseProof, error) {
+	path, err := s.keyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	p := &SparseProof{Bitmap: make([]byte, s.depth/8)}
+	for h := 0; h < s.depth; h++ {
+		if sibling, ok := s.nodes[s.nodeKey(h, sparseSibling(path, h))]; ok {
+			p.Bitmap[h/8] |= 1 << uint(h%8)
+			p.Siblings = append(p.Siblings, sibling)
+		}
+	}
+	return p, nil
+}
+
+//update sets the hash of the leaf at path and rehashes the nodes above it. Nodes that become the
+//hash of an empty subtree are dropped.
+func (s *SparseMerkleTree) update(path []byte, leaf []byte) error {
+	//Compute every hash first so that the tree is unchanged if hashing fails.
+	hashes := make([][]byte, s.depth+1)
+	hashes[0] = leaf
+	for h := 0; h < s.depth; h++ {
+		sibling, ok := s.nodes[s.nodeKey(h, sparseSibling(path, h))]
+		if !ok {
+			sibling = s.empty[h]
+		}
+		left, right := hashes[h], sibling
+		if sparseBit(path, h) == 1 {
+			left, right = sibling, hashes[h]
+		}
+		var err error
+		if hashes[h+1], err = s.hashChildren(left, right); err != nil {
+			return err
+		}
+	}
+	for h, hash := range hashes {
+		key := s.nodeKey(h, path)
+		if bytes.Equal(hash, s.empty[h]) {
+			delete(s.nodes, key)
+		} else {
+			s.nodes[key] = hash
+		}
+	}
+	s.root = hashes[s.depth]
+	return nil
+}
+
+//keyPath returns the path of the leaf of key, the hash of key.
+func (s *SparseMerkleTree) keyPath(key []byte) ([]byte, error) {
+	h := s.hashStrategy()
+	if _, err := h.Write(key); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+//nodeKey returns the key in the node map of the node at height above the leaf at path: the height
+//followed by the path with the bits below the node cleared.
+func (s *SparseMerkleTree) nodeKey(height int, path []byte) string {
+	k := make([]byte, 2+len(path))
+	binary.BigEndian.PutUint16(k, uint16(height))
+	copy(k[2:], path)
+	prefix := k[2:]
+	for i := 0; i < height; i++ {
+		bit := len(path)*8 - 1 - i
+		prefix[bit/8] &^= 0x80 >> uint(bit%8)
+	}
+	return string(k)
+}
+
+//sparseBit returns the bit of path that selects the child at height: 0 for the left child and 1
+//for the right child.
+func sparseBit(path []byte, height int) int {
+	bit := len(path)*8 - 1 - height
+	return int(path[bit/8]>>uint(7-bit%8)) & 1
+}
+
+//sparseSibling returns a path to the sibling of the node at height above the leaf at path.
+func sparseSibling(path []byte, height int) []byte {
+	bit := len(path)*8 - 1 - height
+	sibling := append([]byte{}, path...)
+	sibling[bit/8] ^= 0x80 >> uint(bit%8)
+	return sibling
+}
+
+//Verify checks the proof against the Merkle Root root of a Sparse Merkle Tree built with
+//hashStrategy. If value is nil the proof must show that key is absent, otherwise it must show that
+//key is present with value. Returns an error if the proof is malformed.
+func (p *SparseProof) Verify(root, key, value []byte, hashStrategy func() hash.Hash) (bool, error) {
+	if hashStrategy == nil {
+		return false, ErrNilHashStrategy
+	}
+	c := config{hashStrategy: hashStrategy, domainSeparation: true}
+	size := hashStrategy().Size()
+	depth := size * 8
+	if len(root) != size {
+		return false, fmt.Errorf("%w: root is %d bytes, expected %d", ErrInvalidHashSize, len(root), size)
+	}
+	if len(p.Bitmap) != depth/8 {
+		return false, fmt.Errorf("error: bitmap is %d bytes, expected %d", len(p.Bitmap), depth/8)
+	}
+	count := 0
+	for h := 0; h < depth; h++ {
+		if p.Bitmap[h/8]&(1<<uint(h%8)) != 0 {
+			count++
+		}
+	}
+	if count != len(p.Siblings) {
+		return false, fmt.Errorf("%w: %d siblings, bitmap expects %d", ErrPathLengthMismatch, len(p.Siblings), count)
+	}
+	for i, sibling := range p.Siblings {
+		if len(sibling) != size {
+			return false, fmt.Errorf("%w: sibling %d is %d bytes, expected %d", ErrInvalidHashSize, i, len(sibling), size)
+		}
+	}
+
+	empty, err := sparseEmptyHashes(&c)
+	if err != nil {
+		return false, err
+	}
+	h := hashStrategy()
+	if _, err := h.Write(key); err != nil {
+		return false, err
+	}
+	path := h.Sum(nil)
+	current := empty[0]
+	if value != nil {
+		if current, err = c.hashLeaf(append(append([]byte{}, path...), value...)); err != nil {
+			return false, err
+		}
+	}
+	next := 0
+	for height := 0; height < depth; height++ {
+		sibling := empty[height]
+		if p.Bitmap[height/8]&(1<<uint(height%8)) != 0 {
+			sibling = p.Siblings[next]
+			next++
+		}
+		left, right := current, sibling
+		if sparseBit(path, height) == 1 {
+			left, right = sibling, current
+		}
+		if current, err = c.hashChildren(left, right); err != nil {
+			return false, err
+		}
+	}
+	return bytes.Equal(current, root), nil
+}
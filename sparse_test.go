@@ -0,0 +1,188 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"testing"
+)
+
+func TestSparseMerkleTree(t *testing.T) {
+	for _, hashStrategy := range []func() hash.Hash{sha256.New, md5.New} {
+		tree, err := NewSparseMerkleTree(hashStrategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		emptyRoot := tree.MerkleRoot()
+		if tree.depth != hashStrategy().Size()*8 {
+			t.Errorf("error: expected depth %d got %d", hashStrategy().Size()*8, tree.depth)
+		}
+
+		keys := make([][]byte, 20)
+		for i := range keys {
+			keys[i] = []byte(fmt.Sprintf("key-%d", i))
+			if err := tree.Set(keys[i], []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if tree.Len() != len(keys) {
+			t.Errorf("error: expected %d keys got %d", len(keys), tree.Len())
+		}
+
+		//The root does not depend on the order of insertion.
+		reversed, err := NewSparseMerkleTree(hashStrategy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := len(keys) - 1; i >= 0; i-- {
+			if err := reversed.Set(keys[i], []byte(fmt.Sprintf("value-%d", i))); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !bytes.Equal(reversed.MerkleRoot(), tree.MerkleRoot()) {
+			t.Errorf("error: expected root %x got %x", tree.MerkleRoot(), reversed.MerkleRoot())
+		}
+
+		root := tree.MerkleRoot()
+		for i, key := range keys {
+			value, ok, err := tree.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok || string(value) != fmt.Sprintf("value-%d", i) {
+				t.Errorf("[key:%d] error: expected value-%d got %q", i, i, value)
+			}
+			p, err := tree.Prove(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := p.Verify(root, key, value, hashStrategy); err != nil || !ok {
+				t.Errorf("[key:%d] error: expected inclusion proof to verify: %v", i, err)
+			}
+			if ok, err := p.Verify(root, key, []byte("other"), hashStrategy); err != nil || ok {
+				t.Errorf("[key:%d] error: expected inclusion proof of a different value to fail: %v", i, err)
+			}
+			if ok, err := p.Verify(root, key, nil, hashStrategy); err != nil || ok {
+				t.Errorf("[key:%d] error: expected non-inclusion proof of a present key to fail: %v", i, err)
+			}
+		}
+
+		absent := []byte("absent")
+		if _, ok, err := tree.Get(absent); err != nil || ok {
+			t.Errorf("error: expected absent key to be missing: %v", err)
+		}
+		p, err := tree.Prove(absent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := p.Verify(root, absent, nil, hashStrategy); err != nil || !ok {
+			t.Errorf("error: expected non-inclusion proof to verify: %v", err)
+		}
+		if ok, err := p.Verify(root, absent, []byte("value"), hashStrategy); err != nil || ok {
+			t.Errorf("error: expected inclusion proof of an absent key to fail: %v", err)
+		}
+		if ok, err := p.Verify(emptyRoot, absent, nil, hashStrategy); err != nil || ok {
+			t.Errorf("error: expected proof against another root to fail: %v", err)
+		}
+
+		//Replacing a value changes the root and restoring it restores the root.
+		if err := tree.Set(keys[3], []byte("changed")); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(tree.MerkleRoot(), root) {
+			t.Error("error: expected root to change")
+		}
+		if err := tree.Set(keys[3], []byte("value-3")); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(tree.MerkleRoot(), root) {
+			t.Error("error: expected root to be restored")
+		}
+
+		for i, key := range keys {
+			ok, err := tree.Delete(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				t.Errorf("[key:%d] error: expected key to be deleted", i)
+			}
+		}
+		if ok, err := tree.Delete(keys[0]); err != nil || ok {
+			t.Errorf("error: expected second delete to report absence: %v", err)
+		}
+		if !bytes.Equal(tree.MerkleRoot(), emptyRoot) {
+			t.Errorf("error: expected empty root %x got %x", emptyRoot, tree.MerkleRoot())
+		}
+		if len(tree.nodes) != 0 || tree.Len() != 0 {
+			t.Errorf("error: expected no nodes left got %d", len(tree.nodes))
+		}
+	}
+}
+
+func TestSparseMerkleTree_ProofSize(t *testing.T) {
+	tree, err := NewSparseMerkleTree(sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 64; i++ {
+		if err := tree.Set([]byte(fmt.Sprintf("key-%d", i)), []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	p, err := tree.Prove([]byte("key-0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	//With 64 random keys only the siblings near the root are not empty.
+	if len(p.Siblings) == 0 || len(p.Siblings) > 20 {
+		t.Errorf("error: expected a few siblings got %d", len(p.Siblings))
+	}
+}
+
+func TestSparseMerkleTree_Errors(t *testing.T) {
+	if _, err := NewSparseMerkleTree(nil); !errors.Is(err, ErrNilHashStrategy) {
+		t.Errorf("error: expected ErrNilHashStrategy got %v", err)
+	}
+	tree, err := NewSparseMerkleTree(sha256.New)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Set([]byte("key"), nil); err == nil {
+		t.Error("error: expected error for nil value")
+	}
+	if err := tree.Set([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Set([]byte("other"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	p, err := tree.Prove([]byte("key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.MerkleRoot()
+	if _, err := p.Verify(root, []byte("key"), []byte("value"), nil); !errors.Is(err, ErrNilHashStrategy) {
+		t.Errorf("error: expected ErrNilHashStrategy got %v", err)
+	}
+	if _, err := p.Verify(root[:4], []byte("key"), []byte("value"), sha256.New); !errors.Is(err, ErrInvalidHashSize) {
+		t.Errorf("error: expected ErrInvalidHashSize got %v", err)
+	}
+	if _, err := p.Verify(root, []byte("key"), []byte("value"), md5.New); err == nil {
+		t.Error("error: expected error for a proof of another hash size")
+	}
+	missing := &SparseProof{Bitmap: p.Bitmap, Siblings: p.Siblings[1:]}
+	if _, err := missing.Verify(root, []byte("key"), []byte("value"), sha256.New); !errors.Is(err, ErrPathLengthMismatch) {
+		t.Errorf("error: expected ErrPathLengthMismatch got %v", err)
+	}
+	short := &SparseProof{Bitmap: p.Bitmap, Siblings: [][]byte{p.Siblings[0][:4]}}
+	if _, err := short.Verify(root, []byte("key"), []byte("value"), sha256.New); !errors.Is(err, ErrInvalidHashSize) {
+		t.Errorf("error: expected ErrInvalidHashSize got %v", err)
+	}
+}
@@ -0,0 +1,372 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+//NodeStore holds the hashes of the nodes of a tree by level, from 0 for the leaves up to the root,
+//and index within the level. The levels are laid out as in memory: under OddLeafDuplicate level 0
+//holds the duplicate padding leaf after the last leaf, and under the other policies a promoted
+//node is stored on both levels. Put may be called concurrently for different positions while a
+//tree is built with WithParallelism.
+type NodeStore interface {
+	Get(level, index int) ([]byte, error)
+	Put(level, index int, hash []byte) error
+}
+
+//errNodeStore is returned by the operations that need the nodes of a tree built with
+//WithNodeStore in memory.
+var errNodeStore = errors.New("error: operation is not supported by a tree with a node store")
+
+//WithNodeStore keeps the hashes of the nodes of the tree in store instead of linked Nodes in
+//memory; only the Merkle Root and the number of leaves are held by the MerkleTree. Such a tree
+//can be built and rebuilt with RebuildTreeWith, hand out merkle paths and proofs by index, compute
+//consistency proofs and be verified, all through the store. It does not keep the content of its
+//leaves, so Leafs is nil and the operations that look up or change content, or MarshalBinary,
+//return an error. It cannot be combined with WithSortedLeaves, WithBloomFilter or
+//WithSubtreeFilters.
+func WithNodeStore(store NodeStore) Option {
+	return func(c *config) {
+		c.store = store
+	}
+}
+
+//storedWidths returns the number of nodes of each level of the tree held in the node store, from
+//the leaves to the root.
+func (m *Tree[T]) storedWidths() []int {
+	w := m.storedLeafs
+	if w%2 == 1 && m.oddLeafPolicy == OddLeafDuplicate {
+		w++
+	}
+	widths := []int{w}
+	for w > 1 {
+		w = (w + 1) / 2
+		widths = append(widths, w)
+	}
+	return widths
+}
+
+//nodeHash returns the hash of the node j of level k.
+func (m *Tree[T]) nodeHash(k, j int) ([]byte, error) {
+	if m.store != nil {
+		return m.store.Get(k, j)
+	}
+	return m.levels[k][j].Hash, nil
+}
+
+//buildStored hashes the contents cs into the node store one level at a time, reading each level
+//back from the store to hash the next, and keeps only the root. Every leaf is hashed before the
+//store is written, so the tree and the store are unchanged if a leaf cannot be hashed. If the
+//store fails the tree keeps its root but the store may hold a partly written tree, and the tree
+//no longer verifies until it is rebuilt.
+func (m *Tree[T]) buildStored(cs []T) error {
+	if len(cs) == 0 {
+		return errors.New("error: cannot construct tree with no content")
+	}
+	leafs := make([][]byte, len(cs))
+	err := m.parallelFor(len(cs), func(lo, hi int) error {
+		for i := lo; i < hi; i++ {
+			hash, err := m.hashValue(cs[i])
+			if err != nil {
+				return &LeafHashError{Index: i, Err: err}
+			}
+			leafs[i] = hash
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	err = m.parallelFor(len(leafs), func(lo, hi int) error {
+		for i := lo; i < hi; i++ {
+			if err := m.store.Put(0, i, leafs[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	width := len(cs)
+	if width%2 == 1 && m.oddLeafPolicy == OddLeafDuplicate {
+		hash, err := m.store.Get(0, width-1)
+		if err != nil {
+			return err
+		}
+		if err := m.store.Put(0, width, hash); err != nil {
+			return err
+		}
+		width++
+	}
+	k := 0
+	for ; width > 1; k++ {
+		next, current := (width+1)/2, width
+		err := m.parallelFor(next, func(lo, hi int) error {
+			for j := lo; j < hi; j++ {
+				left, err := m.store.Get(k, 2*j)
+				if err != nil {
+					return err
+				}
+				right := left
+				if 2*j+1 < current {
+					if right, err = m.store.Get(k, 2*j+1); err != nil {
+						return err
+					}
+				} else if m.oddLeafPolicy != OddLeafDuplicate {
+					if err := m.store.Put(k+1, j, left); err != nil {
+						return err
+					}
+					continue
+				}
+				hash, err := m.hashChildren(left, right)
+				if err != nil {
+					return err
+				}
+				if err := m.store.Put(k+1, j, hash); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		width = next
+	}
+	root, err := m.store.Get(k, 0)
+	if err != nil {
+		return err
+	}
+	m.Root = &NodeOf[T]{Tree: m, Hash: root}
+	m.merkleRoot = root
+	m.Leafs, m.levels, m.leafIndex = nil, nil, nil
+	m.storedLeafs = len(cs)
+	return nil
+}
+
+//storedPath returns the merkle path and indexes of the leaf at position i read from the node
+//store, one sibling per level.
+func (m *Tree[T]) storedPath(i int) ([][]byte, []int64, error) {
+	var merklePath [][]byte
+	var index []int64
+	widths := m.storedWidths()
+	for k, j := 0, i; k+1 < len(widths); k, j = k+1, j/2 {
+		sibling, side := j+1, int64(1)
+		switch {
+		case j%2 == 1:
+			sibling, side = j-1, 0
+		case sibling < widths[k]:
+		case m.oddLeafPolicy == OddLeafDuplicate:
+			sibling = j
+		default:
+			//The node is promoted and has no sibling on this level.
+			continue
+		}
+		hash, err := m.store.Get(k, sibling)
+		if err != nil {
+			return nil, nil, err
+		}
+		merklePath = append(merklePath, hash)
+		index = append(index, side)
+	}
+	return merklePath, index, nil
+}
+
+//verifyStored rehashes every interior node of the node store from its children and checks the
+//result against the stored hash and, at the top, the Merkle Root.
+func (m *Tree[T]) verifyStored() (bool, error) {
+	widths := m.storedWidths()
+	if widths[0] > m.storedLeafs {
+		last, err := m.store.Get(0, m.storedLeafs-1)
+		if err != nil {
+			return false, err
+		}
+		duplicate, err := m.store.Get(0, m.storedLeafs)
+		if err != nil || !bytes.Equal(last, duplicate) {
+			return false, err
+		}
+	}
+	for k := 0; k+1 < len(widths); k++ {
+		for j := 0; j < widths[k+1]; j++ {
+			left, err := m.store.Get(k, 2*j)
+			if err != nil {
+				return false, err
+			}
+			expected := left
+			if 2*j+1 < widths[k] || m.oddLeafPolicy == OddLeafDuplicate {
+				right := left
+				if 2*j+1 < widths[k] {
+					if right, err = m.store.Get(k, 2*j+1); err != nil {
+						return false, err
+					}
+				}
+				if expected, err = m.hashChildren(left, right); err != nil {
+					return false, err
+				}
+			}
+			hash, err := m.store.Get(k+1, j)
+			if err != nil {
+				return false, err
+			}
+			if !bytes.Equal(hash, expected) {
+				return false, nil
+			}
+		}
+	}
+	root, err := m.store.Get(len(widths)-1, 0)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(root, m.merkleRoot), nil
+}
+
+//MemoryStore is a NodeStore that keeps the hashes in memory. It is safe for concurrent use.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	levels [][][]byte
+}
+
+//NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+//Get returns the hash of the node at index of level. Returns an error if it was never stored.
+func (s *MemoryStore) Get(level, index int) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if level < 0 || level >= len(s.levels) || index < 0 || index >= len(s.levels[level]) || s.levels[level][index] == nil {
+		return nil, fmt.Errorf("error: node %d of level %d is not stored", index, level)
+	}
+	return s.levels[level][index], nil
+}
+
+//Put stores a copy of hash as the node at index of level.
+func (s *MemoryStore) Put(level, index int, hash []byte) error {
+	if level < 0 || index < 0 {
+		return fmt.Errorf("error: invalid node %d of level %d", index, level)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.levels) <= level {
+		s.levels = append(s.levels, nil)
+	}
+	for len(s.levels[level]) <= index {
+		s.levels[level] = append(s.levels[level], nil)
+	}
+	s.levels[level][index] = append([]byte{}, hash...)
+	return nil
+}
+
+//FileStore is a NodeStore that keeps each level in a file of fixed size hashes in a directory, so
+//that a node is read with a single ReadAt. It is safe for concurrent use.
+type FileStore struct {
+	mu       sync.Mutex
+	dir      string
+	hashSize int
+	files    []*os.File
+	closed   bool
+}
+
+//NewFileStore returns a FileStore for hashes of hashSize bytes in dir, which is created if it does
+//not exist. Level k is kept in the file level-k. Nodes already stored in dir can be read back.
+func NewFileStore(dir string, hashSize int) (*FileStore, error) {
+	if hashSize <= 0 {
+		return nil, fmt.Errorf("error: invalid hash size %d", hashSize)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, hashSize: hashSize}, nil
+}
+
+//file returns the file of level, opening or, if create is set, creating it.
+func (s *FileStore) file(level int, create bool) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, errors.New("error: file store is closed")
+	}
+	if level < len(s.files) && s.files[level] != nil {
+		return s.files[level], nil
+	}
+	flag := os.O_RDWR
+	if create {
+		flag |= os.O_CREATE
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, fmt.Sprintf("level-%d", level)), flag, 0644)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("error: level %d is not stored", level)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for len(s.files) <= level {
+		s.files = append(s.files, nil)
+	}
+	s.files[level] = f
+	return f, nil
+}
+
+//Get reads the hash of the node at index of level. Returns an error if it was never stored.
+func (s *FileStore) Get(level, index int) ([]byte, error) {
+	if level < 0 || index < 0 {
+		return nil, fmt.Errorf("error: invalid node %d of level %d", index, level)
+	}
+	f, err := s.file(level, false)
+	if err != nil {
+		return nil, err
+	}
+	hash := make([]byte, s.hashSize)
+	if _, err := f.ReadAt(hash, int64(index)*int64(s.hashSize)); err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("error: node %d of level %d is not stored", index, level)
+		}
+		return nil, err
+	}
+	return hash, nil
+}
+
+//Put writes hash as the node at index of level. Returns an error if hash is not of the size the
+//store was created for.
+func (s *FileStore) Put(level, index int, hash []byte) error {
+	if level < 0 || index < 0 {
+		return fmt.Errorf("error: invalid node %d of level %d", index, level)
+	}
+	if len(hash) != s.hashSize {
+		return fmt.Errorf("%w: %d bytes, expected %d", ErrInvalidHashSize, len(hash), s.hashSize)
+	}
+	f, err := s.file(level, true)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(hash, int64(index)*int64(s.hashSize))
+	return err
+}
+
+//Close closes the files of the store.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for _, f := range s.files {
+		if f == nil {
+			continue
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	s.files, s.closed = nil, true
+	return err
+}
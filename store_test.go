@@ -0,0 +1,232 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"math/bits"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+//countingStore counts the reads of a NodeStore.
+type countingStore struct {
+	NodeStore
+	gets int64
+}
+
+func (s *countingStore) Get(level, index int) ([]byte, error) {
+	atomic.AddInt64(&s.gets, 1)
+	return s.NodeStore.Get(level, index)
+}
+
+func TestMerkleTree_NodeStore(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		for _, n := range []int{1, 2, 3, 5, 8, 13, 100} {
+			cs := numberedContents(n)
+			opts := []Option{WithOddLeafPolicy(policy), WithDomainSeparation()}
+			memory, err := NewTreeWithOptions(cs, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, err := NewFileStore(t.TempDir(), 32)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, store := range map[string]NodeStore{"memory": NewMemoryStore(), "file": files} {
+				tree, err := NewTreeWithOptions(cs, append(opts, WithNodeStore(store), WithParallelism(4))...)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tree.Leafs != nil || tree.Len() != n {
+					t.Errorf("[%v %s size:%d] error: expected %d leaves held only by the store", policy, name, n, n)
+				}
+				if !bytes.Equal(tree.MerkleRoot(), memory.MerkleRoot()) {
+					t.Errorf("[%v %s size:%d] error: expected root %x got %x", policy, name, n, memory.MerkleRoot(), tree.MerkleRoot())
+				}
+				if ok, err := tree.VerifyTree(); err != nil || !ok {
+					t.Errorf("[%v %s size:%d] error: expected tree to verify: %v", policy, name, n, err)
+				}
+				for i := 0; i < n; i++ {
+					path, indexes, err := tree.GetMerklePathByIndex(i)
+					if err != nil {
+						t.Fatal(err)
+					}
+					expectedPath, expectedIndexes, _ := memory.GetMerklePathByIndex(i)
+					if !reflect.DeepEqual(path, expectedPath) || !reflect.DeepEqual(indexes, expectedIndexes) {
+						t.Errorf("[%v %s size:%d] error: expected path of leaf %d to match", policy, name, n, i)
+					}
+					p, err := tree.GenerateProof(i)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if ok, err := p.VerifyContent(tree.MerkleRoot(), tree.Len(), cs[i]); err != nil || !ok {
+						t.Errorf("[%v %s size:%d] error: expected proof of leaf %d to verify: %v", policy, name, n, i, err)
+					}
+				}
+				for size := 1; size <= n && policy != OddLeafDuplicate; size++ {
+					root, err := tree.RootAt(size)
+					if err != nil {
+						t.Fatal(err)
+					}
+					expected, _ := memory.RootAt(size)
+					if !bytes.Equal(root, expected) {
+						t.Errorf("[%v %s size:%d] error: expected root at %d to match", policy, name, n, size)
+					}
+				}
+				d, err := Diff(tree, memory)
+				if err != nil || !d.Empty() {
+					t.Errorf("[%v %s size:%d] error: expected no difference from the tree in memory: %v", policy, name, n, err)
+				}
+			}
+			if err := files.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestMerkleTree_NodeStoreReads(t *testing.T) {
+	files, err := NewFileStore(t.TempDir(), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer files.Close()
+	store := &countingStore{NodeStore: files}
+	n := 5000
+	tree, err := NewTreeWithOptions(numberedContents(n), WithNodeStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{0, 1234, n - 1} {
+		atomic.StoreInt64(&store.gets, 0)
+		if _, err := tree.GenerateProof(i); err != nil {
+			t.Fatal(err)
+		}
+		if gets := atomic.LoadInt64(&store.gets); gets > int64(bits.Len(uint(n))) {
+			t.Errorf("[leaf:%d] error: expected at most %d reads got %d", i, bits.Len(uint(n)), gets)
+		}
+	}
+
+	//Nodes written by one store can be read back by another on the same files.
+	reopened, err := NewFileStore(files.dir, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	hash, err := reopened.Get(0, 1234)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := tree.hashContent(numberedContents(n)[1234])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(hash, expected) {
+		t.Error("error: expected reopened store to read the stored leaf")
+	}
+}
+
+func TestMerkleTree_NodeStoreErrors(t *testing.T) {
+	store := NewMemoryStore()
+	cs := numberedContents(9)
+	tree, err := NewTreeWithOptions(cs, WithNodeStore(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Append(cs[0]); !errors.Is(err, errNodeStore) {
+		t.Errorf("error: expected errNodeStore from Append got %v", err)
+	}
+	if _, _, err := tree.UpdateLeaf(0, cs[1]); !errors.Is(err, errNodeStore) {
+		t.Errorf("error: expected errNodeStore from UpdateLeaf got %v", err)
+	}
+	if err := tree.RemoveLeaf(0); !errors.Is(err, errNodeStore) {
+		t.Errorf("error: expected errNodeStore from RemoveLeaf got %v", err)
+	}
+	if _, _, err := tree.GetMerklePath(cs[0]); !errors.Is(err, errNodeStore) {
+		t.Errorf("error: expected errNodeStore from GetMerklePath got %v", err)
+	}
+	if _, err := tree.VerifyContent(cs[0]); !errors.Is(err, errNodeStore) {
+		t.Errorf("error: expected errNodeStore from VerifyContent got %v", err)
+	}
+	if err := tree.RebuildTree(); !errors.Is(err, errNodeStore) {
+		t.Errorf("error: expected errNodeStore from RebuildTree got %v", err)
+	}
+	if _, err := tree.MarshalBinary(); !errors.Is(err, errNodeStore) {
+		t.Errorf("error: expected errNodeStore from MarshalBinary got %v", err)
+	}
+	if _, _, err := tree.ChunkProof(0); !errors.Is(err, errNodeStore) {
+		t.Errorf("error: expected errNodeStore from ChunkProof got %v", err)
+	}
+	if _, err := NewTreeWithOptions(cs, WithNodeStore(store), WithBloomFilter(0.01)); err == nil {
+		t.Error("error: expected error combining a node store with a Bloom filter")
+	}
+
+	//A tampered store fails verification.
+	if err := store.Put(1, 2, bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := tree.VerifyTree(); err != nil || ok {
+		t.Errorf("error: expected tampered store not to verify: %v", err)
+	}
+	if err := tree.RebuildTreeWith(cs[:4]); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := NewTree(cs[:4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := tree.VerifyTree(); err != nil || !ok || !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected rebuilt tree to verify: %v", err)
+	}
+
+	//A leaf that cannot be hashed leaves the tree and the store as they were.
+	failing := sortedContents(12)
+	failing[10] = nil
+	var leafErr *LeafHashError
+	if err := tree.RebuildTreeWith(failing); !errors.As(err, &leafErr) || leafErr.Index != 10 {
+		t.Errorf("error: expected LeafHashError for leaf 10 got %v", err)
+	}
+	if ok, err := tree.VerifyTree(); err != nil || !ok || !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected tree to survive a failed rebuild: %v", err)
+	}
+	p, err := tree.GenerateProof(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := p.VerifyContent(tree.MerkleRoot(), tree.Len(), cs[0]); err != nil || !ok {
+		t.Errorf("error: expected proof after a failed rebuild to verify: %v", err)
+	}
+
+	files, err := NewFileStore(t.TempDir(), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := files.Put(0, 0, []byte{1}); !errors.Is(err, ErrInvalidHashSize) {
+		t.Errorf("error: expected ErrInvalidHashSize got %v", err)
+	}
+	if _, err := files.Get(0, 0); err == nil {
+		t.Error("error: expected error reading a node that was not stored")
+	}
+	if _, err := store.Get(0, 100); err == nil {
+		t.Error("error: expected error reading a node that was not stored")
+	}
+	if err := files.Put(0, 0, make([]byte, 32)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := files.Get(0, 1); err == nil {
+		t.Error("error: expected error reading past the stored nodes")
+	}
+	if err := files.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := files.Get(0, 0); err == nil {
+		t.Error("error: expected error reading from a closed store")
+	}
+	if _, err := NewFileStore(t.TempDir(), 0); err == nil {
+		t.Error("error: expected error for hash size 0")
+	}
+}
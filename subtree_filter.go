@@ -0,0 +1,426 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+//WithSubtreeFilters annotates every node of the tree with a Bloom filter of bits bits and hashes
+//hash functions holding the keys, as returned by keys, of the content of the leaves below it. The
+//filter of an interior node is the union of the filters of its children. Search uses the filters
+//to descend only into subtrees that may hold a key.
+//
+//The filters are committed to by a second hash per node: a leaf commits to
+//hash(0x00 || leaf hash || size || filter) and an interior node to
+//hash(0x01 || left commitment || right commitment || size || filter), where size is bits and
+//hashes as 32-bit big endian integers and filter is the big endian encoding of the 64-bit words of
+//the filter as in BloomFilter.MarshalBinary. The commitment of the
+//root node is returned by FilterRoot and bound into the Merkle Root, which is
+//hash(0x02 || root node hash || filter root) instead of the hash of the root node. Proofs carry the
+//filter root to finish that hash; bare merkle paths, consistency proofs and RootAt only cover the
+//hash of the root node.
+func WithSubtreeFilters(bits, hashes int, keys func(Content) ([][]byte, error)) Option {
+	return func(c *config) {
+		c.subtreeKeys = keys
+		c.subtreeFilterBits = bits
+		c.subtreeFilterHashes = hashes
+	}
+}
+
+//SearchMatch is a leaf returned by Search together with its inclusion proof.
+type SearchMatch struct {
+	Index   int
+	Content Content
+	Proof   *Proof
+}
+
+//SearchProof proves which leaves of a tree with subtree filters hold a key. NodeRoot is the hash
+//of the root node, which the filter root is bound to. Nodes lists the nodes
+//visited by the search in pre-order, starting at the root, skipping nodes promoted by the odd-leaf
+//policy. Each entry is one of:
+//
+//	pruned node    Filter is set and does not contain the key. Hash is the leaf hash of a leaf;
+//	               Left and Right are the filter commitments of the children of an interior node.
+//	candidate leaf Content is set; its filter may contain the key.
+//	expanded node  no field is set; its children follow, left first. The right child of a node
+//	               paired with itself under OddLeafDuplicate is not repeated.
+type SearchProof struct {
+	TreeSize         int
+	HashAlgorithm    crypto.Hash
+	DomainSeparation bool
+	OddLeafPolicy    OddLeafPolicy
+	FilterBits       int
+	FilterHashes     int
+	NodeRoot         []byte
+	Nodes            []SearchProofNode
+}
+
+//SearchProofNode is an entry of SearchProof.Nodes.
+type SearchProofNode struct {
+	Filter  []byte
+	Hash    []byte
+	Left    []byte
+	Right   []byte
+	Content Content
+}
+
+//errSearchRejected is returned while verifying a search proof that is well formed but false.
+var errSearchRejected = errors.New("error: search proof rejected")
+
+//SubtreeFilter returns the Bloom filter of the keys below the node, or nil if the tree was not
+//created with WithSubtreeFilters.
+func (n *NodeOf[T]) SubtreeFilter() *BloomFilter {
+	return n.filter
+}
+
+//FilterRoot returns the filter commitment of the root node, or nil if the tree was not created
+//with WithSubtreeFilters.
+func (m *Tree[T]) FilterRoot() []byte {
+	return m.Root.filterHash
+}
+
+//filterRootPrefix separates the hash binding the filter root into the Merkle Root from leaf and
+//interior hashes.
+const filterRootPrefix byte = 0x02
+
+//bindFilterRoot returns the Merkle Root hash(0x02 || nodeRoot || filterRoot) of a tree with
+//subtree filters whose root node hashes to nodeRoot.
+func (c *config) bindFilterRoot(nodeRoot, filterRoot []byte) ([]byte, error) {
+	return c.hashParts(filterRootPrefix, nodeRoot, filterRoot)
+}
+
+//newSubtreeFilter returns an empty filter of the size configured by WithSubtreeFilters.
+func (c *config) newSubtreeFilter() *BloomFilter {
+	m := uint64(c.subtreeFilterBits)
+	return &BloomFilter{bits: make([]uint64, (m+63)/64), m: m, k: uint32(c.subtreeFilterHashes)}
+}
+
+//leafFilter returns the filter of the keys of content.
+func (c *config) leafFilter(content Content) (*BloomFilter, error) {
+	if content == nil {
+		return nil, errors.New("error: content must not be nil")
+	}
+	keys, err := c.subtreeKeys(content)
+	if err != nil {
+		return nil, err
+	}
+	f := c.newSubtreeFilter()
+	for _, key := range keys {
+		f.Add(key)
+	}
+	return f, nil
+}
+
+//union returns the filter holding the items of b and o, which must have the same size.
+func (b *BloomFilter) union(o *BloomFilter) *BloomFilter {
+	u := &BloomFilter{bits: make([]uint64, len(b.bits)), m: b.m, k: b.k, count: b.count + o.count}
+	for i := range u.bits {
+		u.bits[i] = b.bits[i] | o.bits[i]
+	}
+	return u
+}
+
+//words returns the big endian encoding of the words of the filter.
+func (b *BloomFilter) words() []byte {
+	data := make([]byte, 8*len(b.bits))
+	for i, w := range b.bits {
+		binary.BigEndian.PutUint64(data[8*i:], w)
+	}
+	return data
+}
+
+//hashFilter returns the filter commitment hash(prefix || parts... || size || filter), where size
+//is the number of bits and hashes of the filters as 32-bit big endian integers, so that a proof
+//cannot claim another filter size than the tree was built with.
+func (c *config) hashFilter(prefix byte, filter []byte, parts ...[]byte) ([]byte, error) {
+	var size [8]byte
+	binary.BigEndian.PutUint32(size[:4], uint32(c.subtreeFilterBits))
+	binary.BigEndian.PutUint32(size[4:], uint32(c.subtreeFilterHashes))
+	data := make([][]byte, 0, len(parts)+2)
+	data = append(append(data, parts...), size[:], filter)
+	return c.hashParts(prefix, data...)
+}
+
+//hashParts returns hash(prefix || parts...).
+func (c *config) hashParts(prefix byte, parts ...[]byte) ([]byte, error) {
+	h := c.hashStrategy()
+	data := []byte{prefix}
+	for _, p := range parts {
+		data = append(data, p...)
+	}
+	if _, err := h.Write(data); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+//annotateNode sets the filter commitment of n, and the filter of n if it is an interior node, from
+//its leaf hash and filter or from its children.
+func (m *Tree[T]) annotateNode(n *NodeOf[T]) error {
+	var err error
+	if n.leaf {
+		n.filterHash, err = m.hashFilter(leafPrefix, n.filter.words(), n.Hash)
+		return err
+	}
+	n.filter = n.Left.filter.union(n.Right.filter)
+	n.filterHash, err = m.hashFilter(interiorPrefix, n.filter.words(), n.Left.filterHash, n.Right.filterHash)
+	return err
+}
+
+//verifyFilter recalculates the filter and filter commitment of n from its content or from the
+//filters of its children, which must have been verified already. Returns errNodeMismatch if they
+//differ from the stored ones.
+func (n *NodeOf[T]) verifyFilter() error {
+	c := n.Tree
+	var f *BloomFilter
+	var hash []byte
+	var err error
+	if n.leaf {
+		if f, err = c.leafFilter(c.funcs.content(n.C)); err != nil {
+			return err
+		}
+		hash, err = c.hashFilter(leafPrefix, f.words(), n.Hash)
+	} else {
+		f = n.Left.filter.union(n.Right.filter)
+		hash, err = c.hashFilter(interiorPrefix, f.words(), n.Left.filterHash, n.Right.filterHash)
+	}
+	if err != nil {
+		return err
+	}
+	if n.filter == nil || !bytes.Equal(n.filter.words(), f.words()) || !bytes.Equal(n.filterHash, hash) {
+		return errNodeMismatch
+	}
+	return nil
+}
+
+//containsKey reports whether the keys of content include key.
+func (c *config) containsKey(content Content, key []byte) (bool, error) {
+	keys, err := c.subtreeKeys(content)
+	if err != nil {
+		return false, err
+	}
+	for _, k := range keys {
+		if bytes.Equal(k, key) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+//levelWidths returns the number of nodes of each level of a tree of size leaves built with policy,
+//from the leaves to the root, not counting the duplicate padding leaf. A single leaf paired with
+//itself under OddLeafDuplicate has a root above it.
+func levelWidths(size int, policy OddLeafPolicy) []int {
+	widths := []int{size}
+	if size == 1 && policy == OddLeafDuplicate {
+		return append(widths, 1)
+	}
+	for w := size; w > 1; {
+		w = (w + 1) / 2
+		widths = append(widths, w)
+	}
+	return widths
+}
+
+//promoted reports whether the node j of level k of a tree with the given level widths is the node
+//2j of level k-1 moved up unchanged.
+func promoted(widths []int, k, j int, policy OddLeafPolicy) bool {
+	return policy != OddLeafDuplicate && 2*j+1 == widths[k-1]
+}
+
+//Search returns the leaves whose keys include key, with their inclusion proofs, and a SearchProof
+//that these are all of them. Subtrees whose filter rules out key are not visited. Returns an error
+//if the tree was not created with WithSubtreeFilters or its hash strategy is not a registered
+//crypto.Hash.
+func (m *Tree[T]) Search(key []byte) ([]SearchMatch, *SearchProof, error) {
+	if m.subtreeKeys == nil {
+		return nil, nil, errors.New("error: search requires a tree with subtree filters")
+	}
+	algorithm := hashAlgorithm(m.hashStrategy)
+	if algorithm == 0 {
+		return nil, nil, errors.New("error: hash strategy does not match a registered crypto.Hash")
+	}
+	proof := &SearchProof{
+		TreeSize:         m.leafCount(),
+		HashAlgorithm:    algorithm,
+		DomainSeparation: m.domainSeparation,
+		OddLeafPolicy:    m.oddLeafPolicy,
+		FilterBits:       m.subtreeFilterBits,
+		FilterHashes:     m.subtreeFilterHashes,
+		NodeRoot:         m.Root.Hash,
+	}
+	widths := levelWidths(m.leafCount(), m.oddLeafPolicy)
+	var matches []SearchMatch
+	var visit func(k, j int) error
+	visit = func(k, j int) error {
+		if k > 0 && promoted(widths, k, j, m.oddLeafPolicy) {
+			return visit(k-1, 2*j)
+		}
+		n := m.levels[k][j]
+		if !n.filter.MayContain(key) {
+			entry := SearchProofNode{Filter: n.filter.words(), Hash: n.Hash}
+			if k > 0 {
+				entry = SearchProofNode{Filter: n.filter.words(), Left: n.Left.filterHash, Right: n.Right.filterHash}
+			}
+			proof.Nodes = append(proof.Nodes, entry)
+			return nil
+		}
+		if k == 0 {
+			if n.empty {
+				return fmt.Errorf("error: leaf %d has no content", j)
+			}
+			content := m.funcs.content(n.C)
+			proof.Nodes = append(proof.Nodes, SearchProofNode{Content: content})
+			ok, err := m.containsKey(content, key)
+			if err != nil || !ok {
+				return err
+			}
+			p, err := m.GenerateProof(j)
+			if err != nil {
+				return err
+			}
+			matches = append(matches, SearchMatch{Index: j, Content: content, Proof: p})
+			return nil
+		}
+		proof.Nodes = append(proof.Nodes, SearchProofNode{})
+		if err := visit(k-1, 2*j); err != nil {
+			return err
+		}
+		if 2*j+1 < widths[k-1] {
+			return visit(k-1, 2*j+1)
+		}
+		return nil
+	}
+	if err := visit(len(widths)-1, 0); err != nil {
+		return nil, nil, err
+	}
+	return matches, proof, nil
+}
+
+//Verify checks the proof against the trusted Merkle Root root of a tree whose leaves have keys as
+//returned by keys, and returns the indexes of the leaves whose keys include key. The result is
+//only meaningful if ok is true: every other leaf lies in a subtree whose committed filter rules
+//out key. Returns an error if the proof is malformed.
+func (p *SearchProof) Verify(root []byte, key []byte, keys func(Content) ([][]byte, error)) (matches []int, ok bool, err error) {
+	if keys == nil {
+		return nil, false, errors.New("error: keys must not be nil")
+	}
+	if !p.HashAlgorithm.Available() {
+		return nil, false, fmt.Errorf("error: hash algorithm %v is not available", p.HashAlgorithm)
+	}
+	if p.TreeSize <= 0 || p.FilterBits <= 0 || p.FilterHashes <= 0 {
+		return nil, false, fmt.Errorf("error: invalid search proof of %d leaves with filters of %d bits and %d hashes", p.TreeSize, p.FilterBits, p.FilterHashes)
+	}
+	c := config{
+		hashStrategy:        p.HashAlgorithm.New,
+		domainSeparation:    p.DomainSeparation,
+		oddLeafPolicy:       p.OddLeafPolicy,
+		subtreeKeys:         keys,
+		subtreeFilterBits:   p.FilterBits,
+		subtreeFilterHashes: p.FilterHashes,
+	}
+	if err := c.validate(); err != nil {
+		return nil, false, err
+	}
+	size := p.HashAlgorithm.Size()
+	if len(p.NodeRoot) != size {
+		return nil, false, fmt.Errorf("%w: node root is %d bytes, expected %d", ErrInvalidHashSize, len(p.NodeRoot), size)
+	}
+	widths := levelWidths(p.TreeSize, p.OddLeafPolicy)
+	next := 0
+	var visit func(k, j int) ([]byte, *BloomFilter, error)
+	visit = func(k, j int) ([]byte, *BloomFilter, error) {
+		if k > 0 && promoted(widths, k, j, p.OddLeafPolicy) {
+			return visit(k-1, 2*j)
+		}
+		if next == len(p.Nodes) {
+			return nil, nil, fmt.Errorf("%w: search proof ends before node %d of level %d", ErrPathLengthMismatch, j, k)
+		}
+		entry := p.Nodes[next]
+		next++
+		switch {
+		case entry.Filter != nil:
+			f := c.newSubtreeFilter()
+			if len(entry.Filter) != 8*len(f.bits) {
+				return nil, nil, fmt.Errorf("error: filter of node %d of level %d is %d bytes, expected %d", j, k, len(entry.Filter), 8*len(f.bits))
+			}
+			for i := range f.bits {
+				f.bits[i] = binary.BigEndian.Uint64(entry.Filter[8*i:])
+			}
+			if f.MayContain(key) {
+				return nil, nil, errSearchRejected
+			}
+			parts := [][]byte{entry.Left, entry.Right}
+			prefix := interiorPrefix
+			if k == 0 {
+				parts, prefix = [][]byte{entry.Hash}, leafPrefix
+			}
+			for _, h := range parts {
+				if len(h) != size {
+					return nil, nil, fmt.Errorf("%w: node %d of level %d", ErrInvalidHashSize, j, k)
+				}
+			}
+			hash, err := c.hashFilter(prefix, entry.Filter, parts...)
+			return hash, f, err
+		case entry.Content != nil:
+			if k != 0 {
+				return nil, nil, fmt.Errorf("error: content given for interior node %d of level %d", j, k)
+			}
+			leafHash, err := c.hashContent(entry.Content)
+			if err != nil {
+				return nil, nil, err
+			}
+			f, err := c.leafFilter(entry.Content)
+			if err != nil {
+				return nil, nil, err
+			}
+			ok, err := c.containsKey(entry.Content, key)
+			if err != nil {
+				return nil, nil, err
+			}
+			if ok {
+				matches = append(matches, j)
+			}
+			hash, err := c.hashFilter(leafPrefix, f.words(), leafHash)
+			return hash, f, err
+		default:
+			if k == 0 {
+				return nil, nil, fmt.Errorf("error: leaf %d is neither pruned nor given", j)
+			}
+			left, lf, err := visit(k-1, 2*j)
+			if err != nil {
+				return nil, nil, err
+			}
+			right, rf := left, lf
+			if 2*j+1 < widths[k-1] {
+				if right, rf, err = visit(k-1, 2*j+1); err != nil {
+					return nil, nil, err
+				}
+			}
+			f := lf.union(rf)
+			hash, err := c.hashFilter(interiorPrefix, f.words(), left, right)
+			return hash, f, err
+		}
+	}
+	filterRoot, _, err := visit(len(widths)-1, 0)
+	if err == errSearchRejected {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if next != len(p.Nodes) {
+		return nil, false, fmt.Errorf("%w: %d unused search proof nodes", ErrPathLengthMismatch, len(p.Nodes)-next)
+	}
+	merkleRoot, err := c.bindFilterRoot(p.NodeRoot, filterRoot)
+	if err != nil {
+		return nil, false, err
+	}
+	return matches, bytes.Equal(merkleRoot, root), nil
+}
@@ -0,0 +1,335 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+//tagKeys returns the words of a TestSHA256Content as its keys.
+func tagKeys(c Content) ([][]byte, error) {
+	t, ok := c.(TestSHA256Content)
+	if !ok {
+		return nil, errors.New("error: value is not of type TestSHA256Content")
+	}
+	var keys [][]byte
+	for _, word := range strings.Fields(t.x) {
+		keys = append(keys, []byte(word))
+	}
+	return keys, nil
+}
+
+//taggedContents returns n contents tagged with the remainders of their number.
+func taggedContents(n int) []Content {
+	cs := make([]Content, n)
+	for i := range cs {
+		cs[i] = TestSHA256Content{x: fmt.Sprintf("item-%d mod3-%d mod10-%d", i, i%3, i%10)}
+	}
+	return cs
+}
+
+//expectedMatches returns the positions of the contents whose keys include key.
+func expectedMatches(cs []Content, key string) []int {
+	var indexes []int
+	for i, c := range cs {
+		for _, word := range strings.Fields(c.(TestSHA256Content).x) {
+			if word == key {
+				indexes = append(indexes, i)
+			}
+		}
+	}
+	return indexes
+}
+
+func TestMerkleTree_Search(t *testing.T) {
+	for _, policy := range []OddLeafPolicy{OddLeafDuplicate, OddLeafPromote, OddLeafRFC6962} {
+		for _, n := range []int{1, 2, 7, 50} {
+			cs := taggedContents(n)
+			opts := []Option{WithOddLeafPolicy(policy), WithSubtreeFilters(512, 3, tagKeys)}
+			tree, err := NewTreeWithOptions(cs, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			plain, err := NewTreeWithOptions(cs, WithOddLeafPolicy(policy))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tree.FilterRoot() == nil || plain.FilterRoot() != nil {
+				t.Fatalf("[%v size:%d] error: expected a filter root only with subtree filters", policy, n)
+			}
+			bound, err := tree.bindFilterRoot(plain.MerkleRoot(), tree.FilterRoot())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(tree.MerkleRoot(), bound) {
+				t.Errorf("[%v size:%d] error: expected the Merkle Root to bind the filter root", policy, n)
+			}
+			if v, err := tree.VerifyTree(); err != nil || !v {
+				t.Errorf("[%v size:%d] error: expected tree to be valid: %v", policy, n, err)
+			}
+			for _, key := range []string{"item-0", "item-6", "mod3-1", "mod10-7", "absent"} {
+				matches, proof, err := tree.Search([]byte(key))
+				if err != nil {
+					t.Fatal(err)
+				}
+				expected := expectedMatches(cs, key)
+				var indexes []int
+				for _, match := range matches {
+					indexes = append(indexes, match.Index)
+					if ok, err := match.Proof.VerifyContent(tree.MerkleRoot(), tree.Len(), match.Content); err != nil || !ok {
+						t.Errorf("[%v size:%d %s] error: expected proof of match %d to verify: %v", policy, n, key, match.Index, err)
+					}
+					if ok, err := match.Proof.VerifyContent(plain.MerkleRoot(), tree.Len(), match.Content); err != nil || ok {
+						t.Errorf("[%v size:%d %s] error: expected proof of match %d to fail against the root node hash: %v", policy, n, key, match.Index, err)
+					}
+				}
+				if !reflect.DeepEqual(indexes, expected) {
+					t.Errorf("[%v size:%d %s] error: expected matches %v got %v", policy, n, key, expected, indexes)
+				}
+				verified, ok, err := proof.Verify(tree.MerkleRoot(), []byte(key), tagKeys)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok || !reflect.DeepEqual(verified, expected) {
+					t.Errorf("[%v size:%d %s] error: expected verified matches %v got %v (%t)", policy, n, key, expected, verified, ok)
+				}
+				if key == "absent" && n == 50 && len(proof.Nodes) > 20 {
+					t.Errorf("[%v size:%d %s] error: expected search to prune, visited %d nodes", policy, n, key, len(proof.Nodes))
+				}
+			}
+		}
+	}
+}
+
+func TestMerkleTree_SearchRejectsLies(t *testing.T) {
+	cs := taggedContents(30)
+	tree, err := NewTreeWithOptions(cs, WithSubtreeFilters(256, 2, tagKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, proof, err := tree.Search([]byte("mod10-4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.MerkleRoot()
+
+	//Hiding a matching leaf by claiming an empty filter must be detected.
+	leaf := tree.Leafs[expectedMatches(cs, "mod10-4")[0]]
+	for i, node := range proof.Nodes {
+		if node.Content != leaf.C {
+			continue
+		}
+		forged := *proof
+		forged.Nodes = append([]SearchProofNode{}, proof.Nodes...)
+		forged.Nodes[i] = SearchProofNode{Filter: tree.newSubtreeFilter().words(), Hash: leaf.Hash}
+		_, ok, err := forged.Verify(root, []byte("mod10-4"), tagKeys)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			t.Errorf("[node:%d] error: expected hidden match to be detected", i)
+		}
+		break
+	}
+
+	//Claiming a pruned filter that contains the key is rejected.
+	_, other, err := tree.Search([]byte("absent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := other.Verify(root, []byte("mod10-4"), tagKeys); err != nil || ok {
+		t.Errorf("error: expected proof for another key to fail: %v", err)
+	}
+	if _, ok, err := proof.Verify(root, []byte("mod10-4"), func(c Content) ([][]byte, error) {
+		return [][]byte{[]byte("mod10-4")}, nil
+	}); err != nil || ok {
+		t.Errorf("error: expected proof checked with other keys to fail: %v", err)
+	}
+
+	//The filter size is committed, so a proof cannot change it to hide a match.
+	for name, resize := range map[string]func(*SearchProof){
+		"bits":   func(p *SearchProof) { p.FilterBits -= 6 },
+		"hashes": func(p *SearchProof) { p.FilterHashes++ },
+	} {
+		for _, key := range []string{"mod10-4", "absent"} {
+			resized := *other
+			resize(&resized)
+			if _, ok, err := resized.Verify(root, []byte(key), tagKeys); ok {
+				t.Errorf("[%s %s] error: expected proof with another filter size to fail: %v", name, key, err)
+			}
+		}
+	}
+
+	//The node root must be the one the filter root is bound to.
+	moved := *proof
+	moved.NodeRoot = tree.Leafs[0].Hash
+	if _, ok, err := moved.Verify(root, []byte("mod10-4"), tagKeys); err != nil || ok {
+		t.Errorf("error: expected proof with another node root to fail: %v", err)
+	}
+
+	truncated := *proof
+	truncated.Nodes = proof.Nodes[:len(proof.Nodes)-1]
+	if _, _, err := truncated.Verify(root, []byte("mod10-4"), tagKeys); !errors.Is(err, ErrPathLengthMismatch) {
+		t.Errorf("error: expected ErrPathLengthMismatch got %v", err)
+	}
+	extended := *proof
+	extended.Nodes = append(append([]SearchProofNode{}, proof.Nodes...), SearchProofNode{})
+	if _, _, err := extended.Verify(root, []byte("mod10-4"), tagKeys); !errors.Is(err, ErrPathLengthMismatch) {
+		t.Errorf("error: expected ErrPathLengthMismatch got %v", err)
+	}
+}
+
+func TestMerkleTree_SubtreeFiltersMutations(t *testing.T) {
+	all := taggedContents(40)
+	opts := []Option{WithOddLeafPolicy(OddLeafPromote), WithSubtreeFilters(512, 3, tagKeys)}
+	tree, err := NewTreeWithOptions(all[:25], opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Append(all[25:]...); err != nil {
+		t.Fatal(err)
+	}
+	expected, err := NewTreeWithOptions(all, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.FilterRoot(), expected.FilterRoot()) || !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected appended filter root %x got %x", expected.FilterRoot(), tree.FilterRoot())
+	}
+	parallel, err := NewTreeWithOptions(all, append(opts, WithParallelism(4))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parallel.FilterRoot(), expected.FilterRoot()) || !bytes.Equal(parallel.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected parallel filter root %x got %x", expected.FilterRoot(), parallel.FilterRoot())
+	}
+
+	changed := append([]Content{}, all...)
+	changed[12] = TestSHA256Content{x: "item-12 special"}
+	if _, _, err := tree.UpdateLeaf(12, changed[12]); err != nil {
+		t.Fatal(err)
+	}
+	changed = append(changed[:3], changed[4:]...)
+	if err := tree.RemoveLeaf(3); err != nil {
+		t.Fatal(err)
+	}
+	expected, err = NewTreeWithOptions(changed, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.FilterRoot(), expected.FilterRoot()) || !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected filter root %x got %x", expected.FilterRoot(), tree.FilterRoot())
+	}
+	if v, err := tree.VerifyTree(); err != nil || !v {
+		t.Errorf("error: expected mutated tree to be valid: %v", err)
+	}
+	_, proof, err := tree.Search([]byte("special"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	verified, ok, err := proof.Verify(expected.MerkleRoot(), []byte("special"), tagKeys)
+	if err != nil || !ok || !reflect.DeepEqual(verified, []int{11}) {
+		t.Errorf("error: expected match [11] got %v (%t): %v", verified, ok, err)
+	}
+	if !tree.Root.SubtreeFilter().MayContain([]byte("special")) {
+		t.Error("error: expected root filter to hold the updated key")
+	}
+}
+
+func TestMerkleTree_SubtreeFiltersTampered(t *testing.T) {
+	cs := taggedContents(20)
+	tree, err := NewTreeWithOptions(cs, WithSubtreeFilters(256, 3, tagKeys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := tree.MerkleRoot()
+	p, err := tree.GenerateProof(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Hide leaf 7 from searches for its key by emptying its filter and recomputing every filter
+	//commitment above it, so that the tampered filters are consistent with each other.
+	leaf := tree.Leafs[7]
+	leaf.filter = tree.newSubtreeFilter()
+	for n := leaf; n != nil; n = n.Parent {
+		if err := tree.annotateNode(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if v, err := tree.VerifyTree(); err != nil || v {
+		t.Errorf("error: expected tree with a tampered filter to be invalid: %v", err)
+	}
+	_, proof, err := tree.Search([]byte("item-7"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := proof.Verify(root, []byte("item-7"), tagKeys); err != nil || ok {
+		t.Errorf("error: expected search proof over a tampered filter to fail: %v", err)
+	}
+	tampered, err := tree.GenerateProof(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := tampered.VerifyContent(root, tree.Len(), cs[0]); err != nil || ok {
+		t.Errorf("error: expected proof with a tampered filter root to fail: %v", err)
+	}
+	forged := *p
+	forged.FilterRoot = tree.FilterRoot()
+	if ok, err := forged.VerifyContent(root, tree.Len(), cs[0]); err != nil || ok {
+		t.Errorf("error: expected proof with a forged filter root to fail: %v", err)
+	}
+	if ok, err := p.VerifyContent(root, tree.Len(), cs[0]); err != nil || !ok {
+		t.Errorf("error: expected proof of the untampered tree to verify: %v", err)
+	}
+	for name, decode := range map[string]func(*Proof) (*Proof, error){
+		"json": func(p *Proof) (*Proof, error) {
+			data, err := p.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			var decoded Proof
+			return &decoded, decoded.UnmarshalJSON(data)
+		},
+		"binary": func(p *Proof) (*Proof, error) {
+			data, err := p.MarshalBinary()
+			if err != nil {
+				return nil, err
+			}
+			var decoded Proof
+			return &decoded, decoded.UnmarshalBinary(data)
+		},
+	} {
+		decoded, err := decode(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !equalProofs(p, decoded) {
+			t.Errorf("[%s] error: expected proof %+v got %+v", name, p, decoded)
+		}
+	}
+}
+
+func TestMerkleTree_SubtreeFiltersErrors(t *testing.T) {
+	if _, err := NewTreeWithOptions(table[0].contents, WithSubtreeFilters(0, 3, tagKeys)); err == nil {
+		t.Error("error: expected error for a filter of 0 bits")
+	}
+	if _, err := NewTreeWithOptions(taggedContents(4), WithSubtreeFilters(64, 1, func(Content) ([][]byte, error) {
+		return nil, errFailingContent
+	})); !errors.Is(err, errFailingContent) {
+		t.Errorf("error: expected keys error got %v", err)
+	}
+	tree, err := NewTree(taggedContents(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := tree.Search([]byte("item-1")); err == nil {
+		t.Error("error: expected error searching a tree without subtree filters")
+	}
+}
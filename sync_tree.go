@@ -0,0 +1,208 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"sync"
+)
+
+//SyncTree is a MerkleTree that is safe for concurrent use. Reads, such as paths, proofs, roots
+//and verification, run in parallel with each other while mutations, such as appending, updating,
+//removing and rebuilding, are serialized and exclusive. Every call sees the root and leaves of the
+//tree between two mutations, never a partially updated tree.
+type SyncTree struct {
+	mu   sync.RWMutex
+	tree *MerkleTree
+}
+
+//NewSyncTree creates a new concurrency safe Merkle Tree using the content cs configured by opts.
+func NewSyncTree(cs []Content, opts ...Option) (*SyncTree, error) {
+	t, err := NewTreeWithOptions(cs, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &SyncTree{tree: t}, nil
+}
+
+//NewSyncTreeFrom makes the existing tree m safe for concurrent use. m must not be used directly
+//afterwards, only through the returned SyncTree.
+func NewSyncTreeFrom(m *MerkleTree) *SyncTree {
+	return &SyncTree{tree: m}
+}
+
+//View calls fn with the tree while holding a read lock, so that several reads observe the same
+//snapshot of the tree. fn must not modify the tree or retain it after returning.
+func (s *SyncTree) View(fn func(m *MerkleTree) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(s.tree)
+}
+
+//Update calls fn with the tree while holding the write lock, so that several mutations appear
+//to readers as one. fn must not retain the tree after returning.
+func (s *SyncTree) Update(fn func(m *MerkleTree) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return fn(s.tree)
+}
+
+//MerkleRoot returns the unverified Merkle Root of the tree.
+func (s *SyncTree) MerkleRoot() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.MerkleRoot()
+}
+
+//Len returns the number of contents held by the tree.
+func (s *SyncTree) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.leafCount()
+}
+
+//GetMerklePath returns the merkle path and indexes of the first leaf holding content.
+func (s *SyncTree) GetMerklePath(content Content) ([][]byte, []int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.GetMerklePath(content)
+}
+
+//GetMerklePathByIndex returns the merkle path and indexes of the leaf at position i.
+func (s *SyncTree) GetMerklePathByIndex(i int) ([][]byte, []int64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.GetMerklePathByIndex(i)
+}
+
+//IndexesOf returns the positions of all leaves holding content, in ascending order.
+func (s *SyncTree) IndexesOf(content Content) ([]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.IndexesOf(content)
+}
+
+//LeafIndex returns the position of the first leaf whose node hash is hash.
+func (s *SyncTree) LeafIndex(hash []byte) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.LeafIndex(hash)
+}
+
+//HasLeafHash reports whether the tree holds a leaf whose node hash is hash.
+func (s *SyncTree) HasLeafHash(hash []byte) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.HasLeafHash(hash)
+}
+
+//GenerateProof returns the inclusion proof for the leaf at index. The Root of the proof is the
+//Merkle Root of the same snapshot.
+func (s *SyncTree) GenerateProof(index int) (*Proof, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.GenerateProof(index)
+}
+
+//GenerateProofs returns an inclusion proof for every leaf holding content.
+func (s *SyncTree) GenerateProofs(content Content) ([]*Proof, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.GenerateProofs(content)
+}
+
+//ConsistencyProof returns the RFC 6962 consistency proof between the first oldSize and the first
+//newSize leaves.
+func (s *SyncTree) ConsistencyProof(oldSize, newSize int) ([][]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.ConsistencyProof(oldSize, newSize)
+}
+
+//RootAt returns the Merkle Root of the tree built from the first size leaves.
+func (s *SyncTree) RootAt(size int) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.RootAt(size)
+}
+
+//VerifyTree validates the hashes at each level of the tree. Mutations wait until it returns.
+func (s *SyncTree) VerifyTree() (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.VerifyTree()
+}
+
+//VerifyContent indicates whether content is in the tree and the hashes on its path are valid.
+func (s *SyncTree) VerifyContent(content Content) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.VerifyContent(content)
+}
+
+//MarshalBinary implements encoding.BinaryMarshaler.
+func (s *SyncTree) MarshalBinary() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.MarshalBinary()
+}
+
+//String returns a string representation of the tree.
+func (s *SyncTree) String() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.tree.String()
+}
+
+//Append adds the contents cs as new leaves after the existing ones.
+func (s *SyncTree) Append(cs ...Content) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.Append(cs...)
+}
+
+//UpdateLeaf replaces the content of the leaf at index with c and returns the Merkle Root before
+//and after the update.
+func (s *SyncTree) UpdateLeaf(index int, c Content) ([]byte, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.UpdateLeaf(index, c)
+}
+
+//RemoveLeaf removes the leaf at index.
+func (s *SyncTree) RemoveLeaf(index int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.RemoveLeaf(index)
+}
+
+//RemoveContent removes every leaf holding content and returns the number of leaves removed.
+func (s *SyncTree) RemoveContent(content Content) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.RemoveContent(content)
+}
+
+//RebuildTree rebuilds the tree reusing only the content that it holds in the leaves.
+func (s *SyncTree) RebuildTree() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.RebuildTree()
+}
+
+//RebuildTreeWith replaces the content of the tree and does a complete rebuild.
+func (s *SyncTree) RebuildTreeWith(cs []Content) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.tree.RebuildTreeWith(cs)
+}
+
+//UnmarshalBinary implements encoding.BinaryUnmarshaler.
+func (s *SyncTree) UnmarshalBinary(data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tree == nil {
+		s.tree = &MerkleTree{}
+	}
+	return s.tree.UnmarshalBinary(data)
+}
@@ -0,0 +1,164 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package merkletree
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+var (
+	_ encoding.BinaryMarshaler   = (*SyncTree)(nil)
+	_ encoding.BinaryUnmarshaler = (*SyncTree)(nil)
+)
+
+//TestSyncTree_Concurrent is meant to be run with -race. Readers check that the proofs and paths
+//they get verify against the root of the same snapshot while writers mutate the tree.
+func TestSyncTree_Concurrent(t *testing.T) {
+	cs := numberedContents(33)
+	tree, err := NewSyncTree(cs, WithOddLeafPolicy(OddLeafRFC6962))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for w := 0; w < 2; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				c := TestSHA256Content{x: fmt.Sprintf("writer-%d-%d", w, i)}
+				var err error
+				switch i % 4 {
+				case 0:
+					err = tree.Append(c)
+				case 1:
+					_, _, err = tree.UpdateLeaf(i%10, c)
+				case 2:
+					err = tree.RemoveLeaf(0)
+				case 3:
+					err = tree.RebuildTree()
+				}
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(w)
+	}
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				err := tree.View(func(m *MerkleTree) error {
+					index := (r + i) % m.leafCount()
+					p, err := m.GenerateProof(index)
+					if err != nil {
+						return err
+					}
+					ok, err := p.Verify(m.MerkleRoot(), m.Len(), m.Leafs[index].Hash)
+					if err != nil {
+						return err
+					}
+					if !ok {
+						return fmt.Errorf("error: proof of leaf %d does not verify", index)
+					}
+					return nil
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+				p, err := tree.GenerateProof(0)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if _, err := tree.VerifyTree(); err != nil {
+					errs <- err
+					return
+				}
+				if p.Root == nil {
+					errs <- fmt.Errorf("error: proof without root")
+					return
+				}
+			}
+		}(r)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	v, err := tree.VerifyTree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v {
+		t.Error("error: expected tree to be valid")
+	}
+}
+
+func TestSyncTree_Methods(t *testing.T) {
+	cs := numberedContents(9)
+	tree, err := NewSyncTree(cs, WithOddLeafPolicy(OddLeafPromote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := NewTreeWithOptions(cs, WithOddLeafPolicy(OddLeafPromote))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected root %x got %x", expected.MerkleRoot(), tree.MerkleRoot())
+	}
+	if tree.Len() != len(cs) {
+		t.Errorf("error: expected %d contents got %d", len(cs), tree.Len())
+	}
+	if ok, err := tree.VerifyContent(cs[4]); err != nil || !ok {
+		t.Errorf("error: expected content to verify: %v", err)
+	}
+	if indexes, err := tree.IndexesOf(cs[4]); err != nil || len(indexes) != 1 || indexes[0] != 4 {
+		t.Errorf("error: expected content at [4] got %v: %v", indexes, err)
+	}
+	path, index, err := tree.GetMerklePath(cs[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := VerifyMerklePath(tree.MerkleRoot(), cs[4], path, index, sha256.New); err != nil || !ok {
+		t.Errorf("error: expected merkle path to verify: %v", err)
+	}
+	if _, err := tree.ConsistencyProof(3, 9); err != nil {
+		t.Error(err)
+	}
+
+	if err := tree.Update(func(m *MerkleTree) error {
+		if err := m.RemoveLeaf(8); err != nil {
+			return err
+		}
+		return m.Append(cs[8])
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected root to be restored")
+	}
+
+	data, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded SyncTree
+	if err := loaded.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(loaded.MerkleRoot(), expected.MerkleRoot()) {
+		t.Errorf("error: expected decoded root %x got %x", expected.MerkleRoot(), loaded.MerkleRoot())
+	}
+}
@@ -0,0 +1,598 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+/*Package treesync reconciles two merkletree.MerkleTree over a stream.
+
+One peer runs Serve with its tree and the other runs Sync with its own. Sync compares the trees
+level by level, as merkletree.Diff does, asking Serve only for the hashes of the subtrees that
+still differ, then fetches the differing leaves and applies them so that its tree ends up with the
+leaves, and the Merkle Root, of the served tree. Running Sync in both directions reconciles the
+trees both ways.
+
+Wire format
+
+Every message is a frame of a type byte, a big endian uint32 length and a payload of that many
+bytes. All integers are big endian.
+
+	hello   0x01  magic "MRKS", version uint8, hash algorithm uint32 (crypto.Hash),
+	              flags uint8 (bit 0: domain separation), odd-leaf policy uint8,
+	              leaves uint64 (between 1 and 2^24), Merkle Root
+	hashes  0x02  request: count uint32, count times level uint8 and index uint64
+	              reply:   count hashes, in the order of the request
+	leaves  0x03  request: count uint32, count times index uint64
+	              reply:   count times length uint32 and the leaf encoded by the Codec
+	done    0x04  empty, ends the session
+	error   0x05  a message explaining why the server rejected the last request
+
+Sync sends hello and Serve replies with its own. Sync then sends one hashes request per level it
+descends and leaves requests of at most 1024 leaves each, and ends with done. Serve answers each
+request with a frame of the same type, or an error frame. A hashes request names the node of a
+level that covers the leaves [index*2^level, (index+1)*2^level), which both trees must hold.*/
+package treesync
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/cbergoon/merkletree"
+)
+
+//Frame types of the wire format.
+const (
+	frameHello  = 0x01
+	frameHashes = 0x02
+	frameLeaves = 0x03
+	frameDone   = 0x04
+	frameError  = 0x05
+)
+
+//helloMagic starts every hello frame.
+var helloMagic = [4]byte{'M', 'R', 'K', 'S'}
+
+//protocolVersion is the version of the wire format sent in hello.
+const protocolVersion = 1
+
+//maxFrameSize is the largest frame payload accepted, in bytes.
+const maxFrameSize = 1 << 26
+
+//leafBatch is the largest number of leaves fetched in one round trip.
+const leafBatch = 1024
+
+//maxLeaves is the largest number of leaves a peer may announce in hello.
+const maxLeaves = 1 << 24
+
+//Codec converts the content of the leaves to and from bytes for the leaves frame.
+type Codec interface {
+	Marshal(content merkletree.Content) ([]byte, error)
+	Unmarshal(data []byte) (merkletree.Content, error)
+}
+
+//Stats reports what a session cost one peer. RoundTrips counts the requests sent by Sync and
+//answered by Serve, including hello; the final done frame is not counted.
+type Stats struct {
+	BytesWritten int64
+	BytesRead    int64
+	RoundTrips   int
+}
+
+//Result is the outcome of Sync: the leaves that differed from the served tree, by position, and
+//the cost of the session.
+type Result struct {
+	Diff merkletree.TreeDiff
+	Stats
+}
+
+//conn frames messages over a stream and counts the bytes moved.
+type conn struct {
+	r     *bufio.Reader
+	w     io.Writer
+	stats *Stats
+}
+
+//countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n *int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+//countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n *int64
+}
+
+func (c countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+func newConn(rw io.ReadWriter, stats *Stats) *conn {
+	return &conn{
+		r:     bufio.NewReader(countingReader{rw, &stats.BytesRead}),
+		w:     countingWriter{rw, &stats.BytesWritten},
+		stats: stats,
+	}
+}
+
+//send writes a frame of type t holding payload.
+func (c *conn) send(t byte, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("error: frame of %d bytes exceeds %d", len(payload), maxFrameSize)
+	}
+	frame := make([]byte, 5, 5+len(payload))
+	frame[0] = t
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	_, err := c.w.Write(append(frame, payload...))
+	return err
+}
+
+//receive reads the next frame and returns its type and payload.
+func (c *conn) receive() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	size := binary.BigEndian.Uint32(header[1:])
+	if size > maxFrameSize {
+		return 0, nil, fmt.Errorf("error: frame of %d bytes exceeds %d", size, maxFrameSize)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return 0, nil, err
+	}
+	return header[0], payload, nil
+}
+
+//call sends a request of type t and returns the payload of the reply, which must have the same
+//type.
+func (c *conn) call(t byte, payload []byte) ([]byte, error) {
+	if err := c.send(t, payload); err != nil {
+		return nil, err
+	}
+	rt, reply, err := c.receive()
+	if err != nil {
+		return nil, err
+	}
+	c.stats.RoundTrips++
+	if rt == frameError {
+		return nil, fmt.Errorf("error: peer rejected request: %s", reply)
+	}
+	if rt != t {
+		return nil, fmt.Errorf("error: expected frame type %d got %d", t, rt)
+	}
+	return reply, nil
+}
+
+//hello describes the tree of a peer.
+type hello struct {
+	algorithm        crypto.Hash
+	domainSeparation bool
+	policy           merkletree.OddLeafPolicy
+	leaves           int
+	root             []byte
+}
+
+func newHello(tree *merkletree.MerkleTree) (hello, error) {
+	h := hello{
+		algorithm:        tree.HashAlgorithm(),
+		domainSeparation: tree.DomainSeparation(),
+		policy:           tree.OddLeafPolicy(),
+		leaves:           tree.Len(),
+		root:             tree.MerkleRoot(),
+	}
+	if h.algorithm == 0 {
+		return h, errors.New("error: hash strategy does not match a registered crypto.Hash")
+	}
+	return h, nil
+}
+
+func (h hello) marshal() []byte {
+	var buf bytes.Buffer
+	buf.Write(helloMagic[:])
+	buf.WriteByte(protocolVersion)
+	var flags byte
+	if h.domainSeparation {
+		flags |= 1
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(h.algorithm))
+	buf.WriteByte(flags)
+	buf.WriteByte(byte(h.policy))
+	binary.Write(&buf, binary.BigEndian, uint64(h.leaves))
+	buf.Write(h.root)
+	return buf.Bytes()
+}
+
+func parseHello(data []byte) (hello, error) {
+	var h hello
+	if len(data) < 19 || !bytes.Equal(data[:4], helloMagic[:]) {
+		return h, errors.New("error: data is not a sync hello")
+	}
+	if data[4] != protocolVersion {
+		return h, fmt.Errorf("error: unsupported sync protocol version %d", data[4])
+	}
+	h.algorithm = crypto.Hash(binary.BigEndian.Uint32(data[5:]))
+	if !h.algorithm.Available() {
+		return h, fmt.Errorf("error: hash algorithm %v is not available", h.algorithm)
+	}
+	h.domainSeparation = data[9]&1 != 0
+	h.policy = merkletree.OddLeafPolicy(data[10])
+	leaves := binary.BigEndian.Uint64(data[11:])
+	h.root = data[19:]
+	if leaves == 0 || leaves > maxLeaves || len(h.root) != h.algorithm.Size() {
+		return h, errors.New("error: invalid sync hello")
+	}
+	h.leaves = int(leaves)
+	return h, nil
+}
+
+//matches returns an error unless both hellos describe trees hashed in the same way.
+func (h hello) matches(o hello) error {
+	if h.algorithm != o.algorithm || h.domainSeparation != o.domainSeparation || h.policy != o.policy {
+		return fmt.Errorf("error: peer tree uses %v, domain separation %t and %v; expected %v, %t and %v",
+			o.algorithm, o.domainSeparation, o.policy, h.algorithm, h.domainSeparation, h.policy)
+	}
+	return nil
+}
+
+//block is the node of a level covering the leaves [index*2^level, (index+1)*2^level).
+type block struct {
+	level int
+	index int
+}
+
+//Serve answers the requests of a peer running Sync against tree until the peer sends done.
+//The tree must not change during the session.
+func Serve(rw io.ReadWriter, tree *merkletree.MerkleTree, codec Codec) (Stats, error) {
+	var stats Stats
+	c := newConn(rw, &stats)
+	local, err := newHello(tree)
+	if err != nil {
+		return stats, err
+	}
+	t, payload, err := c.receive()
+	if err != nil {
+		return stats, err
+	}
+	if t != frameHello {
+		return stats, fmt.Errorf("error: expected hello got frame type %d", t)
+	}
+	remote, err := parseHello(payload)
+	if err != nil {
+		c.send(frameError, []byte(err.Error()))
+		return stats, err
+	}
+	if err := local.matches(remote); err != nil {
+		c.send(frameError, []byte(err.Error()))
+		return stats, err
+	}
+	if err := c.send(frameHello, local.marshal()); err != nil {
+		return stats, err
+	}
+	stats.RoundTrips++
+	for {
+		t, payload, err := c.receive()
+		if err != nil {
+			return stats, err
+		}
+		var reply []byte
+		switch t {
+		case frameDone:
+			return stats, nil
+		case frameHashes:
+			reply, err = serveHashes(tree, payload)
+		case frameLeaves:
+			reply, err = serveLeaves(tree, codec, payload)
+		default:
+			err = fmt.Errorf("error: unexpected frame type %d", t)
+		}
+		if err == nil && len(reply) > maxFrameSize {
+			err = fmt.Errorf("error: reply of %d bytes exceeds %d", len(reply), maxFrameSize)
+		}
+		if err != nil {
+			c.send(frameError, []byte(err.Error()))
+			return stats, err
+		}
+		if err := c.send(t, reply); err != nil {
+			return stats, err
+		}
+		stats.RoundTrips++
+	}
+}
+
+//readCount returns the count at the start of payload, checking that the rest holds count entries
+//of size bytes.
+func readCount(payload []byte, size int) (int, error) {
+	if len(payload) < 4 {
+		return 0, errors.New("error: truncated request")
+	}
+	count := int(binary.BigEndian.Uint32(payload))
+	if len(payload)-4 != count*size {
+		return 0, fmt.Errorf("error: request of %d bytes does not hold %d entries", len(payload), count)
+	}
+	return count, nil
+}
+
+func serveHashes(tree *merkletree.MerkleTree, payload []byte) ([]byte, error) {
+	count, err := readCount(payload, 9)
+	if err != nil {
+		return nil, err
+	}
+	var reply []byte
+	for i := 0; i < count; i++ {
+		entry := payload[4+9*i:]
+		index := binary.BigEndian.Uint64(entry[1:])
+		if index > uint64(tree.Len()) {
+			return nil, fmt.Errorf("error: block %d out of range", index)
+		}
+		hash, err := tree.BlockHash(int(entry[0]), int(index))
+		if err != nil {
+			return nil, err
+		}
+		reply = append(reply, hash...)
+	}
+	return reply, nil
+}
+
+func serveLeaves(tree *merkletree.MerkleTree, codec Codec, payload []byte) ([]byte, error) {
+	count, err := readCount(payload, 8)
+	if err != nil {
+		return nil, err
+	}
+	var reply []byte
+	for i := 0; i < count; i++ {
+		index := binary.BigEndian.Uint64(payload[4+8*i:])
+		if index >= uint64(tree.Len()) {
+			return nil, fmt.Errorf("error: leaf index %d out of range [0, %d)", index, tree.Len())
+		}
+		if index >= uint64(len(tree.Leafs)) {
+			return nil, errors.New("error: served tree does not hold the content of its leaves")
+		}
+		data, err := codec.Marshal(tree.Leafs[index].C)
+		if err != nil {
+			return nil, err
+		}
+		if len(reply)+4+len(data) > maxFrameSize {
+			return nil, fmt.Errorf("error: leaves reply exceeds %d bytes", maxFrameSize)
+		}
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(data)))
+		reply = append(append(reply, size[:]...), data...)
+	}
+	return reply, nil
+}
+
+//Sync updates tree to hold the leaves of the tree served by the peer at the other end of rw and
+//returns the leaves that differed. Only the hashes of subtrees that differ and the differing
+//leaves are transferred. The leaves of tree must hold their content. Returns an error if the trees
+//are not hashed in the same way, if a fetched leaf does not match the hash sent by the peer or if
+//the updated tree does not have the Merkle Root announced by the peer; tree is left unchanged if
+//an error is returned.
+func Sync(rw io.ReadWriter, tree *merkletree.MerkleTree, codec Codec) (*Result, error) {
+	res := &Result{}
+	c := newConn(rw, &res.Stats)
+	local, err := newHello(tree)
+	if err != nil {
+		return res, err
+	}
+	//The original content restores the tree if the update does not match the peer.
+	original := make([]merkletree.Content, tree.Len())
+	for i := range original {
+		if i >= len(tree.Leafs) || tree.Leafs[i].C == nil {
+			return res, errors.New("error: tree does not hold the content of its leaves")
+		}
+		original[i] = tree.Leafs[i].C
+	}
+	payload, err := c.call(frameHello, local.marshal())
+	if err != nil {
+		return res, err
+	}
+	remote, err := parseHello(payload)
+	if err != nil {
+		return res, err
+	}
+	if err := local.matches(remote); err != nil {
+		return res, err
+	}
+	leafHashes, err := compare(c, tree, remote, &res.Diff)
+	if err != nil {
+		return res, err
+	}
+	contents, err := fetch(c, codec, append(append([]int{}, res.Diff.Changed...), res.Diff.OnlyB...))
+	if err != nil {
+		return res, err
+	}
+	if err := c.send(frameDone, nil); err != nil {
+		return res, err
+	}
+	for i, index := range res.Diff.Changed {
+		hash, err := leafHash(remote, contents[i])
+		if err != nil {
+			return res, err
+		}
+		if !bytes.Equal(hash, leafHashes[index]) {
+			return res, fmt.Errorf("error: leaf %d does not match the hash sent by the peer", index)
+		}
+	}
+	if err := apply(tree, &res.Diff, contents); err != nil {
+		return res, restore(tree, original, err)
+	}
+	if !bytes.Equal(tree.MerkleRoot(), remote.root) {
+		return res, restore(tree, original, errors.New("error: synced tree does not match the root announced by the peer"))
+	}
+	return res, nil
+}
+
+//restore rebuilds tree from its original content after the update failed with err, and returns
+//err.
+func restore(tree *merkletree.MerkleTree, original []merkletree.Content, err error) error {
+	if rerr := tree.RebuildTreeWith(original); rerr != nil {
+		return fmt.Errorf("%v; restoring the tree failed: %v", err, rerr)
+	}
+	return err
+}
+
+//leafHash returns the hash of the leaf holding content in a tree hashed as described by h.
+func leafHash(h hello, content merkletree.Content) ([]byte, error) {
+	hash, err := content.CalculateHash()
+	if err != nil || !h.domainSeparation {
+		return hash, err
+	}
+	//Leaves of trees with domain separation hash to hash(0x00 || content hash).
+	d := h.algorithm.New()
+	if _, err := d.Write(append([]byte{0x00}, hash...)); err != nil {
+		return nil, err
+	}
+	return d.Sum(nil), nil
+}
+
+//compare fills d with the differences between tree and the tree described by remote, asking the
+//peer for the hashes of one level of blocks per round trip. Returns the hashes the peer sent for
+//the leaves at d.Changed.
+func compare(c *conn, tree *merkletree.MerkleTree, remote hello, d *merkletree.TreeDiff) (map[int][]byte, error) {
+	na, nb := tree.Len(), remote.leaves
+	common := na
+	if nb < common {
+		common = nb
+	}
+	for i := common; i < na; i++ {
+		d.OnlyA = append(d.OnlyA, i)
+	}
+	for i := common; i < nb; i++ {
+		d.OnlyB = append(d.OnlyB, i)
+	}
+	if na == nb && bytes.Equal(tree.MerkleRoot(), remote.root) {
+		return nil, nil
+	}
+	//expand adds the largest complete blocks below the node j of level k to blocks.
+	var expand func(blocks []block, k, j int) []block
+	expand = func(blocks []block, k, j int) []block {
+		lo := j << uint(k)
+		if lo >= common {
+			return blocks
+		}
+		if lo+1<<uint(k) <= common {
+			return append(blocks, block{k, j})
+		}
+		blocks = expand(blocks, k-1, 2*j)
+		return expand(blocks, k-1, 2*j+1)
+	}
+	k := 0
+	for 1<<uint(k) < common {
+		k++
+	}
+	blocks := expand(nil, k, 0)
+	size := remote.algorithm.Size()
+	leafHashes := make(map[int][]byte)
+	for len(blocks) > 0 {
+		request := make([]byte, 4, 4+9*len(blocks))
+		binary.BigEndian.PutUint32(request, uint32(len(blocks)))
+		for _, b := range blocks {
+			var entry [9]byte
+			entry[0] = byte(b.level)
+			binary.BigEndian.PutUint64(entry[1:], uint64(b.index))
+			request = append(request, entry[:]...)
+		}
+		reply, err := c.call(frameHashes, request)
+		if err != nil {
+			return nil, err
+		}
+		if len(reply) != size*len(blocks) {
+			return nil, fmt.Errorf("error: expected %d hashes got %d bytes", len(blocks), len(reply))
+		}
+		var next []block
+		for i, b := range blocks {
+			hash, err := tree.BlockHash(b.level, b.index)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(hash, reply[size*i:size*(i+1)]) {
+				continue
+			}
+			if b.level == 0 {
+				d.Changed = append(d.Changed, b.index)
+				leafHashes[b.index] = reply[size*i : size*(i+1)]
+				continue
+			}
+			next = append(next, block{b.level - 1, 2 * b.index}, block{b.level - 1, 2*b.index + 1})
+		}
+		blocks = next
+	}
+	//Blocks are refined level by level, so leaves of larger blocks are found first.
+	sort.Ints(d.Changed)
+	return leafHashes, nil
+}
+
+//fetch returns the leaves at indexes of the served tree, leafBatch at a time.
+func fetch(c *conn, codec Codec, indexes []int) ([]merkletree.Content, error) {
+	var contents []merkletree.Content
+	for lo := 0; lo < len(indexes); lo += leafBatch {
+		hi := lo + leafBatch
+		if hi > len(indexes) {
+			hi = len(indexes)
+		}
+		request := make([]byte, 4+8*(hi-lo))
+		binary.BigEndian.PutUint32(request, uint32(hi-lo))
+		for i, index := range indexes[lo:hi] {
+			binary.BigEndian.PutUint64(request[4+8*i:], uint64(index))
+		}
+		reply, err := c.call(frameLeaves, request)
+		if err != nil {
+			return nil, err
+		}
+		for i := lo; i < hi; i++ {
+			if len(reply) < 4 || uint64(len(reply)-4) < uint64(binary.BigEndian.Uint32(reply)) {
+				return nil, fmt.Errorf("error: truncated leaf %d", indexes[i])
+			}
+			size := binary.BigEndian.Uint32(reply)
+			content, err := codec.Unmarshal(reply[4 : 4+size])
+			if err != nil {
+				return nil, err
+			}
+			contents = append(contents, content)
+			reply = reply[4+size:]
+		}
+		if len(reply) != 0 {
+			return nil, errors.New("error: unexpected data after leaves")
+		}
+	}
+	return contents, nil
+}
+
+//apply updates tree with contents, which hold the leaves at d.Changed followed by the ones at
+//d.OnlyB, and drops the leaves at d.OnlyA.
+func apply(tree *merkletree.MerkleTree, d *merkletree.TreeDiff, contents []merkletree.Content) error {
+	changed, added := contents[:len(d.Changed)], contents[len(d.Changed):]
+	if len(d.OnlyA) > 0 {
+		//Removing leaves one by one rebuilds the tree each time, so rebuild it once.
+		cs := make([]merkletree.Content, 0, d.OnlyA[0])
+		for _, l := range tree.Leafs[:d.OnlyA[0]] {
+			cs = append(cs, l.C)
+		}
+		for i, index := range d.Changed {
+			cs[index] = changed[i]
+		}
+		return tree.RebuildTreeWith(cs)
+	}
+	for i, index := range d.Changed {
+		if _, _, err := tree.UpdateLeaf(index, changed[i]); err != nil {
+			return err
+		}
+	}
+	if len(added) > 0 {
+		return tree.Append(added...)
+	}
+	return nil
+}
@@ -0,0 +1,274 @@
+// Copyright 2017 Cameron Bergoon
+// Licensed under the MIT License, see LICENCE file for details.
+
+package treesync
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/cbergoon/merkletree"
+)
+
+//testContent implements the Content interface provided by merkletree.
+type testContent struct {
+	x string
+}
+
+//CalculateHash hashes the values of a testContent
+func (t testContent) CalculateHash() ([]byte, error) {
+	h := sha256.New()
+	if _, err := h.Write([]byte(t.x)); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+//Equals tests for equality of two Contents
+func (t testContent) Equals(other merkletree.Content) (bool, error) {
+	o, ok := other.(testContent)
+	if !ok {
+		return false, errors.New("error: value is not of type testContent")
+	}
+	return t.x == o.x, nil
+}
+
+//stringCodec encodes a testContent as its string.
+type stringCodec struct{}
+
+func (stringCodec) Marshal(c merkletree.Content) ([]byte, error) {
+	t, ok := c.(testContent)
+	if !ok {
+		return nil, errors.New("error: value is not of type testContent")
+	}
+	return []byte(t.x), nil
+}
+
+func (stringCodec) Unmarshal(data []byte) (merkletree.Content, error) {
+	return testContent{x: string(data)}, nil
+}
+
+func contents(n int) []merkletree.Content {
+	cs := make([]merkletree.Content, n)
+	for i := range cs {
+		cs[i] = testContent{x: fmt.Sprintf("record-%d", i)}
+	}
+	return cs
+}
+
+//session runs Sync on local against Serve on remote over net.Pipe.
+func session(local, remote *merkletree.MerkleTree, codec Codec) (*Result, Stats, error, error) {
+	a, b := net.Pipe()
+	served := make(chan error, 1)
+	var stats Stats
+	go func() {
+		var err error
+		stats, err = Serve(b, remote, codec)
+		b.Close()
+		served <- err
+	}()
+	res, err := Sync(a, local, codec)
+	a.Close()
+	serveErr := <-served
+	return res, stats, err, serveErr
+}
+
+func TestSync(t *testing.T) {
+	for _, policy := range []merkletree.OddLeafPolicy{merkletree.OddLeafDuplicate, merkletree.OddLeafPromote, merkletree.OddLeafRFC6962} {
+		for _, tc := range []struct {
+			localSize, remoteSize int
+			changed               []int
+		}{
+			{1, 1, nil},
+			{100, 100, nil},
+			{100, 100, []int{3, 64, 99}},
+			{100, 37, []int{36}},
+			{37, 100, []int{0, 20}},
+			{1, 3000, nil},
+		} {
+			remoteContents := contents(tc.remoteSize)
+			for _, i := range tc.changed {
+				remoteContents[i] = testContent{x: "changed"}
+			}
+			opts := []merkletree.Option{merkletree.WithOddLeafPolicy(policy), merkletree.WithDomainSeparation()}
+			local, err := merkletree.NewTreeWithOptions(contents(tc.localSize), opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			remote, err := merkletree.NewTreeWithOptions(remoteContents, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected, err := merkletree.Diff(local, remote)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, stats, err, serveErr := session(local, remote, stringCodec{})
+			if err != nil || serveErr != nil {
+				t.Fatalf("[%v %d/%d] error: sync failed: %v, %v", policy, tc.localSize, tc.remoteSize, err, serveErr)
+			}
+			if !reflect.DeepEqual(res.Diff, *expected) {
+				t.Errorf("[%v %d/%d] error: expected diff %v got %v", policy, tc.localSize, tc.remoteSize, *expected, res.Diff)
+			}
+			if !bytes.Equal(local.MerkleRoot(), remote.MerkleRoot()) || local.Len() != remote.Len() {
+				t.Errorf("[%v %d/%d] error: expected synced tree to match", policy, tc.localSize, tc.remoteSize)
+			}
+			if ok, err := local.VerifyTree(); err != nil || !ok {
+				t.Errorf("[%v %d/%d] error: expected synced tree to verify: %v", policy, tc.localSize, tc.remoteSize, err)
+			}
+			if res.BytesWritten != stats.BytesRead || res.BytesRead != stats.BytesWritten || res.RoundTrips != stats.RoundTrips {
+				t.Errorf("[%v %d/%d] error: expected the peers to agree on the cost: %+v %+v", policy, tc.localSize, tc.remoteSize, res.Stats, stats)
+			}
+		}
+	}
+}
+
+func TestSync_Cost(t *testing.T) {
+	remote, err := merkletree.NewTree(contents(1 << 12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := merkletree.NewTree(contents(1 << 12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, _, err, serveErr := session(local, remote, stringCodec{})
+	if err != nil || serveErr != nil {
+		t.Fatal(err, serveErr)
+	}
+	if res.RoundTrips != 1 {
+		t.Errorf("error: expected identical trees to sync in 1 round trip got %d", res.RoundTrips)
+	}
+
+	if _, _, err := remote.UpdateLeaf(1234, testContent{x: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	res, _, err, serveErr = session(local, remote, stringCodec{})
+	if err != nil || serveErr != nil {
+		t.Fatal(err, serveErr)
+	}
+	//Hello, one hashes request per level of 4096 leaves and one leaves request.
+	if res.RoundTrips != 1+13+1 {
+		t.Errorf("error: expected 15 round trips got %d", res.RoundTrips)
+	}
+	if res.BytesWritten+res.BytesRead > 2000 {
+		t.Errorf("error: expected a single change to cost little, used %d bytes", res.BytesWritten+res.BytesRead)
+	}
+	if !reflect.DeepEqual(res.Diff.Changed, []int{1234}) {
+		t.Errorf("error: expected leaf 1234 to differ got %v", res.Diff.Changed)
+	}
+}
+
+//failingCodec cannot encode any content.
+type failingCodec struct{ stringCodec }
+
+func (failingCodec) Marshal(merkletree.Content) ([]byte, error) {
+	return nil, errors.New("error: cannot encode")
+}
+
+//hugeCodec encodes every leaf in a whole frame.
+type hugeCodec struct{ stringCodec }
+
+func (hugeCodec) Marshal(merkletree.Content) ([]byte, error) {
+	return make([]byte, maxFrameSize), nil
+}
+
+//lyingCodec decodes every leaf as other content.
+type lyingCodec struct{ stringCodec }
+
+func (lyingCodec) Unmarshal(data []byte) (merkletree.Content, error) {
+	return testContent{x: "lie"}, nil
+}
+
+func TestSync_Errors(t *testing.T) {
+	remote, err := merkletree.NewTree(contents(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newLocal := func(opts ...merkletree.Option) *merkletree.MerkleTree {
+		local, err := merkletree.NewTreeWithOptions(contents(12), opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return local
+	}
+	for name, local := range map[string]*merkletree.MerkleTree{
+		"hash":   newLocal(merkletree.WithHashStrategy(md5.New)),
+		"domain": newLocal(merkletree.WithDomainSeparation()),
+		"policy": newLocal(merkletree.WithOddLeafPolicy(merkletree.OddLeafRFC6962)),
+	} {
+		if _, _, err, serveErr := session(local, remote, stringCodec{}); err == nil || serveErr == nil {
+			t.Errorf("[%s] error: expected trees hashed differently to be rejected", name)
+		}
+	}
+
+	local := newLocal()
+	if _, _, err := remote.UpdateLeaf(4, testContent{x: "changed"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err, serveErr := session(local, remote, failingCodec{}); err == nil || serveErr == nil {
+		t.Error("error: expected a codec error to end the session")
+	}
+	if _, _, err, serveErr := session(local, remote, hugeCodec{}); err == nil || serveErr == nil {
+		t.Error("error: expected a reply too large for a frame to end the session")
+	}
+	//A changed leaf is checked against the hash the peer sent for it and an added leaf against
+	//the root; either way the tree is left as it was.
+	for _, size := range []int{12, 8} {
+		cs := contents(size)
+		if size < remote.Len() {
+			cs[4] = testContent{x: "changed"}
+		}
+		local := newLocal()
+		if err := local.RebuildTreeWith(cs); err != nil {
+			t.Fatal(err)
+		}
+		root := local.MerkleRoot()
+		if _, _, err, _ := session(local, remote, lyingCodec{}); err == nil {
+			t.Errorf("[%d] error: expected leaves that do not match the peer to be rejected", size)
+		}
+		if !bytes.Equal(local.MerkleRoot(), root) || local.Len() != size {
+			t.Errorf("[%d] error: expected a failed sync to leave the tree unchanged", size)
+		}
+		if ok, err := local.VerifyTree(); err != nil || !ok {
+			t.Errorf("[%d] error: expected the restored tree to verify: %v", size, err)
+		}
+	}
+
+	//A peer may not announce more leaves than can be synced.
+	huge, err := newHello(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	huge.leaves = maxLeaves + 1
+	a, b := net.Pipe()
+	go func(b net.Conn) {
+		c := newConn(b, &Stats{})
+		c.receive()
+		c.send(frameHello, huge.marshal())
+		b.Close()
+	}(b)
+	if _, err := Sync(a, newLocal(), stringCodec{}); err == nil {
+		t.Error("error: expected a hello announcing too many leaves to be rejected")
+	}
+	a.Close()
+
+	//A peer that is not running Serve.
+	a, b = net.Pipe()
+	go func(b net.Conn) {
+		buf := make([]byte, 64)
+		b.Read(buf)
+		b.Write([]byte{frameHello, 0, 0, 0, 4, 'J', 'U', 'N', 'K'})
+		b.Close()
+	}(b)
+	if _, err := Sync(a, newLocal(), stringCodec{}); err == nil {
+		t.Error("error: expected an invalid hello to be rejected")
+	}
+	a.Close()
+}